@@ -6,6 +6,7 @@ import (
 	"net"
 
 	C "../constant"
+	"../dialer"
 )
 
 // DirectAdapter 是一个直接连接的适配器
@@ -49,15 +50,23 @@ func (d *Direct) Name() string {
 // addr: 目标地址信息
 // 返回: 直接连接适配器和可能的错误
 func (d *Direct) Generator(addr *C.Addr) (adapter C.ProxyAdapter, err error) {
-	// 建立到目标地址的 TCP 连接
+	// UDP 请求建立已连接的 UDP 套接字，其余情况建立 TCP 连接
+	network := "tcp"
+	if addr.NetWork == C.UDP {
+		network = "udp"
+	}
+
 	// 使用 net.JoinHostPort 将主机名和端口组合成 "host:port" 格式
-	c, err := net.Dial("tcp", net.JoinHostPort(addr.String(), addr.Port))
+	// 通过dialer建立连接，可以绑定出口网卡，避免流量回到TUN设备
+	c, err := dialer.Dial(network, net.JoinHostPort(addr.String(), addr.Port))
 	if err != nil {
 		return
 	}
 
 	// 设置 TCP 连接的 KeepAlive 属性，保持连接活跃
-	c.(*net.TCPConn).SetKeepAlive(true)
+	if tcp, ok := c.(*net.TCPConn); ok {
+		tcp.SetKeepAlive(true)
+	}
 
 	// 创建带流量统计的连接跟踪器，并返回直接连接适配器
 	// NewTrafficTrack 包装原始连接以跟踪流量使用情况
@@ -10,6 +10,7 @@ import (
 	"strconv"
 
 	C "../constant"
+	"../dialer"
 
 	"github.com/riobard/go-shadowsocks2/core"
 	"github.com/riobard/go-shadowsocks2/socks"
@@ -53,8 +54,13 @@ func (ss *ShadowSocks) Name() string {
 // Generator 根据目标地址生成一个 Shadowsocks 连接适配器
 // 这个方法实现了 Proxy 接口，用于创建到目标地址的连接
 func (ss *ShadowSocks) Generator(addr *C.Addr) (adapter C.ProxyAdapter, err error) {
+	// UDP 请求通过 Shadowsocks 的 UDP 中继转发
+	if addr.NetWork == C.UDP {
+		return ss.generatePacket(addr)
+	}
+
 	// 建立到 Shadowsocks 服务器的 TCP 连接
-	c, err := net.Dial("tcp", ss.server)
+	c, err := dialer.Dial("tcp", ss.server)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error", ss.server)
 	}
@@ -72,6 +78,68 @@ func (ss *ShadowSocks) Generator(addr *C.Addr) (adapter C.ProxyAdapter, err erro
 	return &ShadowsocksAdapter{conn: NewTrafficTrack(c, ss.traffic)}, err
 }
 
+// generatePacket 建立到 Shadowsocks 服务器的 UDP 中继
+// 每个数据包前都会带上目标地址，由服务器解析后转发
+func (ss *ShadowSocks) generatePacket(addr *C.Addr) (C.ProxyAdapter, error) {
+	server, err := dialer.ResolveUDPAddr(ss.server)
+	if err != nil {
+		return nil, fmt.Errorf("%s resolve error", ss.server)
+	}
+
+	pc, err := dialer.ListenPacket("udp", "")
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &ssPacketConn{
+		PacketConn: ss.cipher.PacketConn(pc),
+		server:     server,
+		target:     serializesSocksAddr(addr),
+	}
+	return &ShadowsocksAdapter{conn: NewTrafficTrack(conn, ss.traffic)}, nil
+}
+
+// ssPacketConn 将 Shadowsocks UDP 中继包装为 net.Conn
+// 写入时添加目标地址头，读取时去掉服务器返回的地址头
+type ssPacketConn struct {
+	net.PacketConn
+	server net.Addr
+	target []byte
+}
+
+func (pc *ssPacketConn) Write(b []byte) (int, error) {
+	packet := bytes.Join([][]byte{pc.target, b}, []byte(""))
+	if _, err := pc.PacketConn.WriteTo(packet, pc.server); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (pc *ssPacketConn) Read(b []byte) (int, error) {
+	buf := make([]byte, len(b)+socks.MaxAddrLen)
+	for {
+		n, from, err := pc.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		// 忽略非服务器发来的数据包
+		if from.String() != pc.server.String() {
+			continue
+		}
+
+		target := socks.SplitAddr(buf[:n])
+		if target == nil {
+			continue
+		}
+		return copy(b, buf[len(target):n]), nil
+	}
+}
+
+func (pc *ssPacketConn) RemoteAddr() net.Addr {
+	return pc.server
+}
+
 // NewShadowSocks 创建一个新的 Shadowsocks 代理实例
 // name: 代理名称
 // ssURL: Shadowsocks URL 格式，如 "ss://method:password@server:port"
@@ -20,6 +20,7 @@ type URLTest struct {
 	fast   C.Proxy
 	delay  time.Duration
 	done   chan struct{}
+	once   sync.Once
 }
 
 func (u *URLTest) Name() string {
@@ -30,8 +31,13 @@ func (u *URLTest) Generator(addr *C.Addr) (adapter C.ProxyAdapter, err error) {
 	return u.fast.Generator(addr)
 }
 
+// Start runs the speed test every delay until Close
+func (u *URLTest) Start() {
+	go u.loop()
+}
+
 func (u *URLTest) Close() {
-	u.done <- struct{}{}
+	u.once.Do(func() { close(u.done) })
 }
 
 func (u *URLTest) loop() {
@@ -122,6 +128,10 @@ func selectFast(in chan interface{}) chan interface{} {
 }
 
 func NewURLTest(name string, proxys []C.Proxy, rawURL string, delay time.Duration) (*URLTest, error) {
+	if len(proxys) == 0 {
+		return nil, fmt.Errorf("url-test %s has no proxy", name)
+	}
+
 	u, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, err
@@ -155,6 +165,5 @@ func NewURLTest(name string, proxys []C.Proxy, rawURL string, delay time.Duratio
 		delay:  delay,
 		done:   make(chan struct{}),
 	}
-	go urlTest.loop()
 	return urlTest, nil
 }
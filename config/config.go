@@ -0,0 +1,261 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	C "../constant"
+)
+
+// General is the settings of the process itself, a port of 0 disables the inbound
+type General struct {
+	Port               int
+	SocksPort          int
+	MixedPort          int
+	RedirPort          int
+	TProxyPort         int
+	SSPort             int
+	SSCipher           string
+	SSPassword         string
+	AllowLan           bool
+	BindAddress        []string
+	ExternalController string
+	Secret             string
+	TunDevice          string
+	TunDNSHijack       bool
+	InterfaceName      string
+	RoutingMark        int
+	ASNMMDB            string
+	GeoIPPath          string
+	GeoIPURL           string
+	GeoIPLicenseKey    string
+	GeoIPSHA256        string
+	GeoIPInterval      int
+}
+
+type Proxy struct {
+	Name     string
+	Type     string
+	Server   string
+	Port     int
+	Cipher   string
+	Password string
+}
+
+type ProxyGroup struct {
+	Name    string
+	Type    string
+	Proxies []string
+	URL     string
+	Delay   int
+}
+
+type Rule struct {
+	Type    string
+	Payload string
+	Target  string
+}
+
+// RuleProvider source is a local path or an http(s) url, interval is in seconds
+type RuleProvider struct {
+	Name     string
+	Behavior string
+	Source   string
+	Interval int
+}
+
+// Tunnel is a static port forward, an empty proxy means matching by rules
+type Tunnel struct {
+	Name    string
+	Network string
+	Address string
+	Target  string
+	Proxy   string
+}
+
+type Sniffer struct {
+	TLSPorts   []string
+	HTTPPorts  []string
+	SkipDomain []string
+}
+
+type Config struct {
+	General       General
+	Proxies       []Proxy
+	ProxyGroups   []ProxyGroup
+	Rules         []Rule
+	RuleProviders []RuleProvider
+	Tunnels       []Tunnel
+	Users         map[string]string
+	Sniffer       Sniffer
+
+	lines lines
+}
+
+// Errorf returns an Error at key of section, with the line it's defined at
+func (c *Config) Errorf(section, key string, format string, args ...interface{}) *Error {
+	return &Error{
+		Section: section,
+		Key:     key,
+		Line:    c.lines.get(section, key),
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func defaultGeneral() General {
+	return General{
+		Port:         C.DefalutHTTPPort,
+		SocksPort:    C.DefalutSOCKSPort,
+		BindAddress:  []string{"127.0.0.1"},
+		TunDNSHijack: true,
+	}
+}
+
+// Format is "yaml" when path ends with .yaml or .yml, "ini" otherwise
+func Format(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "ini"
+	}
+}
+
+// Load reads a config in the format of its path
+func Load(path string) (*Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(buf, Format(path))
+}
+
+// Parse reads a config in the format "ini" or "yaml"
+func Parse(buf []byte, format string) (*Config, error) {
+	switch format {
+	case "yaml":
+		return ParseYAML(buf)
+	case "ini":
+		return ParseINI(buf)
+	default:
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+// Save replaces the config at path atomically, the mode of the old file is kept
+func Save(path string, buf []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// parseRule splits TYPE,PAYLOAD,TARGET, the payload of logic rules contains commas itself
+func parseRule(line string) (Rule, error) {
+	rule := trimArr(strings.Split(line, ","))
+	if len(rule) < 3 {
+		return Rule{}, fmt.Errorf("expect TYPE,PAYLOAD,TARGET")
+	}
+	return Rule{
+		Type:    rule[0],
+		Payload: strings.Join(rule[1:len(rule)-1], ","),
+		Target:  rule[len(rule)-1],
+	}, nil
+}
+
+// parseBindAddress parses a comma separated list of IPs, "*" means all interfaces
+func parseBindAddress(value string) ([]string, error) {
+	hosts := []string{}
+	for _, host := range strings.Split(value, ",") {
+		host = strings.TrimSpace(host)
+		switch {
+		case host == "":
+			continue
+		case host == "*":
+			return []string{""}, nil
+		case net.ParseIP(strings.Trim(host, "[]")) == nil:
+			return nil, fmt.Errorf("invalid IP %s", host)
+		}
+		hosts = append(hosts, strings.Trim(host, "[]"))
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no address")
+	}
+	return hosts, nil
+}
+
+// bindAddress only listens on loopback unless allow-lan is set
+func bindAddress(allowLan bool, value string) ([]string, error) {
+	if !allowLan {
+		return []string{"127.0.0.1"}, nil
+	}
+	if value == "" {
+		return []string{""}, nil
+	}
+	return parseBindAddress(value)
+}
+
+// tunnelAddress defaults the host of a listen address to 127.0.0.1
+func tunnelAddress(address string) (string, error) {
+	if !strings.Contains(address, ":") {
+		address = ":" + address
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// checkSniffer validates the ports to sniff, 0 isn't a port to sniff on
+func (c *Config) checkSniffer() Errors {
+	errs := Errors{}
+	for _, field := range []struct {
+		key   string
+		ports []string
+	}{
+		{"tls-ports", c.Sniffer.TLSPorts},
+		{"http-ports", c.Sniffer.HTTPPorts},
+	} {
+		for _, port := range field.ports {
+			if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+				errs = append(errs, c.Errorf("Sniffer", field.key, "invalid port %s", port))
+			}
+		}
+	}
+	return errs
+}
+
+func checkPort(port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const iniConfig = `
+[General]
+port = 7890
+socks-port =
+mixed-port = 7894
+allow-lan = true
+bind-address = 192.168.1.1, [fd00::1]
+external-controller = 127.0.0.1:8080
+geoip-url = https://example.com/Country.mmdb
+geoip-update-interval = 86400
+
+[Proxy]
+Proxy1 = ss, server1, 443, AEAD_CHACHA20_POLY1305, password
+
+[Proxy Group]
+Proxy = url-test, Proxy1, http://www.gstatic.com/generate_204, 300
+
+[Rule Provider]
+ads = domain, ads.txt, 86400
+
+[Tunnel]
+ssh = tcp, 2222, git.internal:22, Proxy1
+
+[Authentication]
+alice = password
+
+[Sniffer]
+tls-ports = 443, 8443
+skip-domain = +.apple.com
+
+[Rule]
+AND,((DST-PORT,443),(DOMAIN-SUFFIX,google.com)),Proxy
+FINAL,,DIRECT
+`
+
+const yamlConfig = `
+mixed-port: 7894
+socks-port: 0
+allow-lan: true
+bind-address: 192.168.1.1, [fd00::1]
+external-controller: 127.0.0.1:8080
+geoip-url: https://example.com/Country.mmdb
+geoip-update-interval: 86400
+
+proxies:
+  - name: Proxy1
+    type: ss
+    server: server1
+    port: 443
+    cipher: AEAD_CHACHA20_POLY1305
+    password: password
+
+proxy-groups:
+  - name: Proxy
+    type: url-test
+    proxies: [Proxy1]
+    url: http://www.gstatic.com/generate_204
+    delay: 300
+
+rule-providers:
+  ads:
+    behavior: domain
+    path: ads.txt
+    interval: 86400
+
+tunnels:
+  - name: ssh
+    network: tcp
+    address: "2222"
+    target: git.internal:22
+    proxy: Proxy1
+
+authentication:
+  - alice:password
+
+sniffer:
+  tls-ports: [443, 8443]
+  skip-domain: [+.apple.com]
+
+rules:
+  - AND,((DST-PORT,443),(DOMAIN-SUFFIX,google.com)),Proxy
+  - FINAL,,DIRECT
+`
+
+func TestINIAndYAMLAreEqual(t *testing.T) {
+	fromINI, err := ParseINI([]byte(iniConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := ParseYAML([]byte(yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lines differ between the formats
+	fromINI.lines, fromYAML.lines = nil, nil
+	if !reflect.DeepEqual(fromINI, fromYAML) {
+		t.Fatalf("INI and YAML differ:\n%+v\n%+v", fromINI, fromYAML)
+	}
+
+	general := fromYAML.General
+	if general.Port != 7890 || general.SocksPort != 0 || general.MixedPort != 7894 || !general.TunDNSHijack {
+		t.Fatalf("unexpected general %+v", general)
+	}
+	if !reflect.DeepEqual(general.BindAddress, []string{"192.168.1.1", "fd00::1"}) {
+		t.Fatalf("unexpected bind address %v", general.BindAddress)
+	}
+
+	rule := fromYAML.Rules[0]
+	if rule.Type != "AND" || rule.Payload != "((DST-PORT,443),(DOMAIN-SUFFIX,google.com))" || rule.Target != "Proxy" {
+		t.Fatalf("unexpected rule %+v", rule)
+	}
+
+	if tunnel := fromYAML.Tunnels[0]; tunnel.Address != "127.0.0.1:2222" || tunnel.Proxy != "Proxy1" {
+		t.Fatalf("unexpected tunnel %+v", tunnel)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	for _, parse := range []func([]byte) (*Config, error){ParseINI, ParseYAML} {
+		cfg, err := parse([]byte(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cfg.General, defaultGeneral()) {
+			t.Fatalf("unexpected defaults %+v", cfg.General)
+		}
+	}
+}
+
+func TestParseBindAddress(t *testing.T) {
+	addrs, err := parseBindAddress("192.168.1.1, [fd00::1]")
+	if err != nil || len(addrs) != 2 || addrs[1] != "fd00::1" {
+		t.Fatalf("unexpected %v %v", addrs, err)
+	}
+
+	if addrs, _ := parseBindAddress("*"); len(addrs) != 1 || addrs[0] != "" {
+		t.Fatalf("* should mean all interfaces, got %v", addrs)
+	}
+
+	if _, err := parseBindAddress("localhost"); err == nil {
+		t.Fatal("expected an error for a host name")
+	}
+}
+
+func TestINIErrors(t *testing.T) {
+	_, err := ParseINI([]byte(`
+[General]
+port = 70000
+sock-port = 7891
+
+[Proxy]
+Proxy1 = ss, server1, 443
+Proxy2 = vmess, server2, 443
+
+[Rule]
+DOMAIN-SUFFIX,google.com
+
+[Sniffer]
+tls-ports = 443, 0
+`))
+
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+
+	expected := []Error{
+		{Section: "General", Key: "port", Line: 3},
+		{Section: "General", Key: "sock-port", Line: 4},
+		{Section: "Proxy", Key: "Proxy1", Line: 7},
+		{Section: "Proxy", Key: "Proxy2", Line: 8},
+		{Section: "Rule", Key: "DOMAIN-SUFFIX,google.com", Line: 11},
+		{Section: "Sniffer", Key: "tls-ports", Line: 14},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected errors:\n%v", errs)
+	}
+	for i, e := range expected {
+		if errs[i].Section != e.Section || errs[i].Key != e.Key || errs[i].Line != e.Line || errs[i].Message == "" {
+			t.Errorf("expected %+v, got %+v", e, *errs[i])
+		}
+	}
+}
+
+func TestYAMLErrors(t *testing.T) {
+	_, err := ParseYAML([]byte(`
+proxies:
+  - name: Proxy1
+    type: ss
+    port: 70000
+
+rules:
+  - FINAL,,DIRECT
+  - "DOMAIN-SUFFIX,google.com"
+`))
+
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("unexpected errors %v", err)
+	}
+	if errs[0].Section != "Proxy" || errs[0].Key != "Proxy1" || errs[0].Line != 3 {
+		t.Errorf("unexpected %+v", *errs[0])
+	}
+	if errs[1].Section != "Rule" || errs[1].Line != 9 {
+		t.Errorf("unexpected %+v", *errs[1])
+	}
+
+	// misspelled keys are reported instead of ignored
+	_, err = ParseYAML([]byte("port: 7890\nsocks_port: 7891\n"))
+	if errs, ok := err.(Errors); !ok || len(errs) != 1 || errs[0].Key != "socks_port" || errs[0].Line != 2 {
+		t.Fatalf("expected socks_port at line 2, got %v", err)
+	}
+
+	// syntax errors of yaml keep their line
+	_, err = ParseYAML([]byte("port: 7890\nsocks-port: [\n"))
+	if errs, ok := err.(Errors); !ok || errs[0].Line == 0 {
+		t.Fatalf("expected a line, got %v", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(path, []byte("port: 1\n"), 0600)
+
+	if err := Save(path, []byte(yamlConfig)); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Fatalf("the mode should be kept, got %s", info.Mode())
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.General.Port != 7890 {
+		t.Fatalf("unexpected port %d", cfg.General.Port)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatal("the temporary file should be renamed")
+	}
+}
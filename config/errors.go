@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Error is a problem at a key of a section, Line is 0 when it's unknown
+type Error struct {
+	Section string `json:"section,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	s := ""
+	if e.Line > 0 {
+		s = fmt.Sprintf("line %d: ", e.Line)
+	}
+	if e.Section != "" {
+		s += "[" + e.Section + "] "
+	}
+	if e.Key != "" {
+		s += e.Key + ": "
+	}
+	return s + e.Message
+}
+
+// Errors is every problem found in a config
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// lines maps a section and a key to the line defining it,
+// the empty key holds the line of the section itself
+type lines map[string]int
+
+func (l lines) set(section, key string, line int) {
+	if section == "Rule" {
+		key = ruleKey(key)
+	}
+	id := section + "\x00" + key
+	if _, ok := l[id]; !ok {
+		l[id] = line
+	}
+}
+
+// get falls back to the line of the section
+func (l lines) get(section, key string) int {
+	if section == "Rule" {
+		key = ruleKey(key)
+	}
+	if line, ok := l[section+"\x00"+key]; ok {
+		return line
+	}
+	return l[section+"\x00"]
+}
+
+func ruleKey(line string) string {
+	return strings.Join(trimArr(strings.Split(line, ",")), ",")
+}
+
+func indexINI(buf []byte) lines {
+	l := lines{}
+	section := "DEFAULT"
+	for i, text := range strings.Split(string(buf), "\n") {
+		text = strings.TrimSpace(text)
+		switch {
+		case text == "", text[0] == '#', text[0] == ';':
+		case text[0] == '[':
+			if end := strings.Index(text, "]"); end != -1 {
+				section = strings.TrimSpace(text[1:end])
+				l.set(section, "", i+1)
+			}
+		case text[0] == '`', text[0] == '"':
+			if end := strings.IndexByte(text[1:], text[0]); end != -1 {
+				l.set(section, text[1:end+1], i+1)
+			}
+		default:
+			// the same as ini, keys without a value end at an inline comment
+			if idx := strings.IndexAny(text, "=:"); idx != -1 {
+				l.set(section, strings.TrimSpace(text[:idx]), i+1)
+			} else if idx := strings.IndexAny(text, "#;"); idx != -1 {
+				l.set(section, strings.TrimSpace(text[:idx]), i+1)
+			} else {
+				l.set(section, text, i+1)
+			}
+		}
+	}
+	return l
+}
+
+// yamlSections maps the top level keys of YAML to the sections of INI,
+// other top level keys belong to General
+var yamlSections = map[string]string{
+	"proxies":        "Proxy",
+	"proxy-groups":   "Proxy Group",
+	"rule-providers": "Rule Provider",
+	"tunnels":        "Tunnel",
+	"authentication": "Authentication",
+	"sniffer":        "Sniffer",
+	"rules":          "Rule",
+}
+
+// indexYAML finds the lines of the block style YAML written by hand,
+// keys of flow style lists fall back to the line of their section
+func indexYAML(buf []byte) lines {
+	l := lines{}
+	top, indent := "", 0
+	for i, raw := range strings.Split(string(buf), "\n") {
+		text := strings.TrimSpace(raw)
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		depth := len(raw) - len(strings.TrimLeft(raw, " "))
+		if depth == 0 && !strings.HasPrefix(text, "-") {
+			top, indent = strings.TrimSpace(strings.SplitN(text, ":", 2)[0]), 0
+			if section, ok := yamlSections[top]; ok {
+				l.set(section, "", i+1)
+			} else {
+				l.set("General", top, i+1)
+			}
+			continue
+		}
+		if indent == 0 {
+			indent = depth
+		}
+
+		section := yamlSections[top]
+		item := strings.TrimSpace(strings.TrimPrefix(text, "-"))
+		switch top {
+		case "rules":
+			l.set(section, unquote(item), i+1)
+		case "authentication":
+			l.set(section, strings.SplitN(unquote(item), ":", 2)[0], i+1)
+		case "proxies", "proxy-groups", "tunnels":
+			if strings.HasPrefix(item, "name:") {
+				l.set(section, unquote(strings.TrimSpace(item[5:])), i+1)
+			}
+		case "rule-providers", "sniffer":
+			if depth == indent {
+				l.set(section, unquote(strings.TrimSpace(strings.SplitN(item, ":", 2)[0])), i+1)
+			}
+		}
+	}
+	return l
+}
+
+func unquote(s string) string {
+	if len(s) > 1 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// yamlErrors moves the "line N:" prefix of yaml errors to Line,
+// unknown fields of UnmarshalStrict become unknown keys
+func yamlErrors(err error) Errors {
+	msgs := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs = typeErr.Errors
+	}
+
+	errs := Errors{}
+	for _, msg := range msgs {
+		e := &Error{Message: strings.TrimPrefix(msg, "yaml: ")}
+		if strings.HasPrefix(e.Message, "line ") {
+			if idx := strings.Index(e.Message, ": "); idx != -1 {
+				if line, err := strconv.Atoi(e.Message[5:idx]); err == nil {
+					e.Line, e.Message = line, e.Message[idx+2:]
+				}
+			}
+		}
+		if match := unknownField.FindStringSubmatch(e.Message); match != nil {
+			e.Key, e.Message = match[1], "unknown key"
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// unknownField matches "field socks_port not found in type config.rawConfig"
+var unknownField = regexp.MustCompile(`^field (.+) not found in type \S+$`)
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// knownKeys are the keys of the sections with fixed keys
+var knownKeys = map[string]map[string]bool{
+	"General": setOf(
+		"port", "socks-port", "mixed-port", "redir-port", "tproxy-port", "ss-port",
+		"allow-lan", "bind-address", "ss-cipher", "ss-password", "external-controller", "secret",
+		"tun-device", "tun-dns-hijack", "interface-name", "routing-mark", "asn-mmdb",
+		"geoip-path", "geoip-url", "geoip-license-key", "geoip-sha256", "geoip-update-interval",
+	),
+	"Sniffer": setOf("tls-ports", "http-ports", "skip-domain"),
+}
+
+func setOf(keys ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+// ParseINI reads the positional INI format, all problems are returned as Errors
+func ParseINI(buf []byte) (*Config, error) {
+	cfg, err := ini.LoadSources(ini.LoadOptions{AllowBooleanKeys: true}, buf)
+	if err != nil {
+		return nil, Errors{{Message: err.Error()}}
+	}
+
+	config := &Config{Users: map[string]string{}, lines: indexINI(buf)}
+	general, errs := parseINIGeneral(config, cfg.Section("General"))
+	config.General = general
+
+	// misspelled keys would silently fall back to their defaults
+	for section, known := range knownKeys {
+		for _, key := range cfg.Section(section).Keys() {
+			if !known[key.Name()] {
+				errs = append(errs, config.Errorf(section, key.Name(), "unknown key"))
+			}
+		}
+	}
+
+	// name = ss, server, port, cipher, password
+	for _, key := range cfg.Section("Proxy").Keys() {
+		proxy := trimArr(strings.Split(key.Value(), ","))
+		if proxy[0] != "ss" {
+			errs = append(errs, config.Errorf("Proxy", key.Name(), "unsupported proxy type %s", proxy[0]))
+			continue
+		}
+		if len(proxy) != 5 {
+			errs = append(errs, config.Errorf("Proxy", key.Name(), "expect ss, server, port, cipher, password"))
+			continue
+		}
+		port, err := strconv.Atoi(proxy[2])
+		if err != nil || checkPort(port) != nil {
+			errs = append(errs, config.Errorf("Proxy", key.Name(), "invalid port %s", proxy[2]))
+			continue
+		}
+		config.Proxies = append(config.Proxies, Proxy{
+			Name:     key.Name(),
+			Type:     proxy[0],
+			Server:   proxy[1],
+			Port:     port,
+			Cipher:   proxy[3],
+			Password: proxy[4],
+		})
+	}
+
+	// name = url-test, proxy1, proxy2, ..., url, delay(second)
+	for _, key := range cfg.Section("Proxy Group").Keys() {
+		group := trimArr(strings.Split(key.Value(), ","))
+		if group[0] != "url-test" {
+			errs = append(errs, config.Errorf("Proxy Group", key.Name(), "unsupported group type %s", group[0]))
+			continue
+		}
+		if len(group) < 4 {
+			errs = append(errs, config.Errorf("Proxy Group", key.Name(), "expect url-test, proxies, url, delay"))
+			continue
+		}
+		delay, err := strconv.Atoi(group[len(group)-1])
+		if err != nil || delay <= 0 {
+			errs = append(errs, config.Errorf("Proxy Group", key.Name(), "invalid delay %s", group[len(group)-1]))
+			continue
+		}
+		config.ProxyGroups = append(config.ProxyGroups, ProxyGroup{
+			Name:    key.Name(),
+			Type:    group[0],
+			Proxies: group[1 : len(group)-2],
+			URL:     group[len(group)-2],
+			Delay:   delay,
+		})
+	}
+
+	// name = behavior, path or url, interval(second)
+	for _, key := range cfg.Section("Rule Provider").Keys() {
+		provider := trimArr(strings.Split(key.Value(), ","))
+		if len(provider) < 2 || len(provider) > 3 {
+			errs = append(errs, config.Errorf("Rule Provider", key.Name(), "expect behavior, path or url, interval"))
+			continue
+		}
+
+		interval := 0
+		if len(provider) > 2 {
+			interval, err = strconv.Atoi(provider[2])
+			if err != nil || interval < 0 {
+				errs = append(errs, config.Errorf("Rule Provider", key.Name(), "invalid interval %s", provider[2]))
+				continue
+			}
+		}
+		config.RuleProviders = append(config.RuleProviders, RuleProvider{
+			Name:     key.Name(),
+			Behavior: provider[0],
+			Source:   provider[1],
+			Interval: interval,
+		})
+	}
+
+	for _, key := range cfg.Section("Rule").Keys() {
+		rule, err := parseRule(key.Name())
+		if err != nil {
+			errs = append(errs, config.Errorf("Rule", key.Name(), "%s", err.Error()))
+			continue
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+
+	// name = network, listen address, target[, proxy]
+	for _, key := range cfg.Section("Tunnel").Keys() {
+		fields := trimArr(strings.Split(key.Value(), ","))
+		if len(fields) < 3 || len(fields) > 4 {
+			errs = append(errs, config.Errorf("Tunnel", key.Name(), "expect network, address, target[, proxy]"))
+			continue
+		}
+
+		address, err := tunnelAddress(fields[1])
+		if err != nil {
+			errs = append(errs, config.Errorf("Tunnel", key.Name(), "%s", err.Error()))
+			continue
+		}
+
+		tunnel := Tunnel{
+			Name:    key.Name(),
+			Network: fields[0],
+			Address: address,
+			Target:  fields[2],
+		}
+		if len(fields) == 4 {
+			tunnel.Proxy = fields[3]
+		}
+		config.Tunnels = append(config.Tunnels, tunnel)
+	}
+
+	// user = password, also can be written as user:password
+	for _, key := range cfg.Section("Authentication").Keys() {
+		config.Users[key.Name()] = key.Value()
+	}
+
+	sniffer := cfg.Section("Sniffer")
+	config.Sniffer = Sniffer{
+		TLSPorts:   splitArr(sniffer.Key("tls-ports").String()),
+		HTTPPorts:  splitArr(sniffer.Key("http-ports").String()),
+		SkipDomain: splitArr(sniffer.Key("skip-domain").String()),
+	}
+
+	errs = append(errs, config.checkSniffer()...)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+func parseINIGeneral(config *Config, section *ini.Section) (General, Errors) {
+	general := defaultGeneral()
+	errs := Errors{}
+	ports := []struct {
+		key   string
+		value *int
+	}{
+		{"port", &general.Port},
+		{"socks-port", &general.SocksPort},
+		{"mixed-port", &general.MixedPort},
+		{"redir-port", &general.RedirPort},
+		{"tproxy-port", &general.TProxyPort},
+		{"ss-port", &general.SSPort},
+	}
+	for _, field := range ports {
+		key, err := section.GetKey(field.key)
+		if err != nil {
+			continue
+		}
+
+		// an empty value disables the inbound
+		*field.value = 0
+		if key.Value() == "" {
+			continue
+		}
+
+		port, err := strconv.Atoi(key.Value())
+		if err != nil || checkPort(port) != nil {
+			errs = append(errs, config.Errorf("General", field.key, "invalid port %s", key.Value()))
+			continue
+		}
+		*field.value = port
+	}
+
+	var err error
+	general.AllowLan = section.Key("allow-lan").MustBool(false)
+	general.BindAddress, err = bindAddress(general.AllowLan, section.Key("bind-address").String())
+	if err != nil {
+		errs = append(errs, config.Errorf("General", "bind-address", "%s", err.Error()))
+	}
+
+	general.SSCipher = section.Key("ss-cipher").String()
+	general.SSPassword = section.Key("ss-password").String()
+	general.ExternalController = section.Key("external-controller").String()
+	general.Secret = section.Key("secret").String()
+	general.TunDevice = section.Key("tun-device").String()
+	general.TunDNSHijack = section.Key("tun-dns-hijack").MustBool(true)
+	general.InterfaceName = section.Key("interface-name").String()
+	if key, err := section.GetKey("routing-mark"); err == nil && key.Value() != "" {
+		mark, err := strconv.Atoi(key.Value())
+		if err != nil || mark < 0 {
+			errs = append(errs, config.Errorf("General", "routing-mark", "invalid mark %s", key.Value()))
+		}
+		general.RoutingMark = mark
+	}
+	general.ASNMMDB = section.Key("asn-mmdb").String()
+
+	general.GeoIPPath = section.Key("geoip-path").String()
+	general.GeoIPURL = section.Key("geoip-url").String()
+	general.GeoIPLicenseKey = section.Key("geoip-license-key").String()
+	general.GeoIPSHA256 = section.Key("geoip-sha256").String()
+	if key, err := section.GetKey("geoip-update-interval"); err == nil && key.Value() != "" {
+		interval, err := strconv.Atoi(key.Value())
+		if err != nil || interval < 0 {
+			errs = append(errs, config.Errorf("General", "geoip-update-interval", "invalid interval %s", key.Value()))
+		}
+		general.GeoIPInterval = interval
+	}
+	return general, errs
+}
@@ -0,0 +1,20 @@
+package config
+
+import (
+	"strings"
+)
+
+func trimArr(arr []string) (r []string) {
+	for _, e := range arr {
+		r = append(r, strings.Trim(e, " "))
+	}
+	return
+}
+
+// splitArr splits a comma separated list, an empty value gives nil
+func splitArr(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	return trimArr(strings.Split(value, ","))
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type rawProvider struct {
+	Behavior string `yaml:"behavior"`
+	Path     string `yaml:"path"`
+	URL      string `yaml:"url"`
+	Interval int    `yaml:"interval"`
+}
+
+type rawSniffer struct {
+	TLSPorts   []int    `yaml:"tls-ports"`
+	HTTPPorts  []int    `yaml:"http-ports"`
+	SkipDomain []string `yaml:"skip-domain"`
+}
+
+type rawConfig struct {
+	Port               int    `yaml:"port"`
+	SocksPort          int    `yaml:"socks-port"`
+	MixedPort          int    `yaml:"mixed-port"`
+	RedirPort          int    `yaml:"redir-port"`
+	TProxyPort         int    `yaml:"tproxy-port"`
+	SSPort             int    `yaml:"ss-port"`
+	SSCipher           string `yaml:"ss-cipher"`
+	SSPassword         string `yaml:"ss-password"`
+	AllowLan           bool   `yaml:"allow-lan"`
+	BindAddress        string `yaml:"bind-address"`
+	ExternalController string `yaml:"external-controller"`
+	Secret             string `yaml:"secret"`
+	TunDevice          string `yaml:"tun-device"`
+	TunDNSHijack       bool   `yaml:"tun-dns-hijack"`
+	InterfaceName      string `yaml:"interface-name"`
+	RoutingMark        int    `yaml:"routing-mark"`
+	ASNMMDB            string `yaml:"asn-mmdb"`
+	GeoIPPath          string `yaml:"geoip-path"`
+	GeoIPURL           string `yaml:"geoip-url"`
+	GeoIPLicenseKey    string `yaml:"geoip-license-key"`
+	GeoIPSHA256        string `yaml:"geoip-sha256"`
+	GeoIPInterval      int    `yaml:"geoip-update-interval"`
+
+	Authentication []string `yaml:"authentication"`
+	Proxies        []struct {
+		Name     string `yaml:"name"`
+		Type     string `yaml:"type"`
+		Server   string `yaml:"server"`
+		Port     int    `yaml:"port"`
+		Cipher   string `yaml:"cipher"`
+		Password string `yaml:"password"`
+	} `yaml:"proxies"`
+	ProxyGroups []struct {
+		Name    string   `yaml:"name"`
+		Type    string   `yaml:"type"`
+		Proxies []string `yaml:"proxies"`
+		URL     string   `yaml:"url"`
+		Delay   int      `yaml:"delay"`
+	} `yaml:"proxy-groups"`
+	RuleProviders map[string]rawProvider `yaml:"rule-providers"`
+	Tunnels       []struct {
+		Name    string `yaml:"name"`
+		Network string `yaml:"network"`
+		Address string `yaml:"address"`
+		Target  string `yaml:"target"`
+		Proxy   string `yaml:"proxy"`
+	} `yaml:"tunnels"`
+	Sniffer rawSniffer `yaml:"sniffer"`
+	Rules   []string   `yaml:"rules"`
+}
+
+// ParseYAML reads the YAML format, fields left out keep their defaults,
+// all problems are returned as Errors
+func ParseYAML(buf []byte) (*Config, error) {
+	general := defaultGeneral()
+	raw := &rawConfig{
+		Port:         general.Port,
+		SocksPort:    general.SocksPort,
+		TunDNSHijack: general.TunDNSHijack,
+	}
+	if err := yaml.UnmarshalStrict(buf, raw); err != nil {
+		return nil, yamlErrors(err)
+	}
+
+	config := &Config{Users: map[string]string{}, lines: indexYAML(buf)}
+	config.General = General{
+		Port:               raw.Port,
+		SocksPort:          raw.SocksPort,
+		MixedPort:          raw.MixedPort,
+		RedirPort:          raw.RedirPort,
+		TProxyPort:         raw.TProxyPort,
+		SSPort:             raw.SSPort,
+		SSCipher:           raw.SSCipher,
+		SSPassword:         raw.SSPassword,
+		AllowLan:           raw.AllowLan,
+		ExternalController: raw.ExternalController,
+		Secret:             raw.Secret,
+		TunDevice:          raw.TunDevice,
+		TunDNSHijack:       raw.TunDNSHijack,
+		InterfaceName:      raw.InterfaceName,
+		RoutingMark:        raw.RoutingMark,
+		ASNMMDB:            raw.ASNMMDB,
+		GeoIPPath:          raw.GeoIPPath,
+		GeoIPURL:           raw.GeoIPURL,
+		GeoIPLicenseKey:    raw.GeoIPLicenseKey,
+		GeoIPSHA256:        raw.GeoIPSHA256,
+		GeoIPInterval:      raw.GeoIPInterval,
+	}
+
+	errs := Errors{}
+	ports := []struct {
+		key   string
+		value int
+	}{
+		{"port", raw.Port},
+		{"socks-port", raw.SocksPort},
+		{"mixed-port", raw.MixedPort},
+		{"redir-port", raw.RedirPort},
+		{"tproxy-port", raw.TProxyPort},
+		{"ss-port", raw.SSPort},
+	}
+	for _, port := range ports {
+		if err := checkPort(port.value); err != nil {
+			errs = append(errs, config.Errorf("General", port.key, "%s", err.Error()))
+		}
+	}
+
+	if raw.RoutingMark < 0 {
+		errs = append(errs, config.Errorf("General", "routing-mark", "invalid mark %d", raw.RoutingMark))
+	}
+	if raw.GeoIPInterval < 0 {
+		errs = append(errs, config.Errorf("General", "geoip-update-interval", "invalid interval %d", raw.GeoIPInterval))
+	}
+
+	var err error
+	config.General.BindAddress, err = bindAddress(raw.AllowLan, raw.BindAddress)
+	if err != nil {
+		errs = append(errs, config.Errorf("General", "bind-address", "%s", err.Error()))
+	}
+
+	for _, proxy := range raw.Proxies {
+		if err := checkPort(proxy.Port); err != nil || proxy.Port == 0 {
+			errs = append(errs, config.Errorf("Proxy", proxy.Name, "invalid port %d", proxy.Port))
+			continue
+		}
+		config.Proxies = append(config.Proxies, Proxy{
+			Name:     proxy.Name,
+			Type:     proxy.Type,
+			Server:   proxy.Server,
+			Port:     proxy.Port,
+			Cipher:   proxy.Cipher,
+			Password: proxy.Password,
+		})
+	}
+
+	for _, group := range raw.ProxyGroups {
+		if group.Delay <= 0 {
+			errs = append(errs, config.Errorf("Proxy Group", group.Name, "invalid delay %d", group.Delay))
+			continue
+		}
+		config.ProxyGroups = append(config.ProxyGroups, ProxyGroup{
+			Name:    group.Name,
+			Type:    group.Type,
+			Proxies: group.Proxies,
+			URL:     group.URL,
+			Delay:   group.Delay,
+		})
+	}
+
+	// mappings have no order, sort by name to load providers the same way every time
+	names := []string{}
+	for name := range raw.RuleProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		provider := raw.RuleProviders[name]
+		source := provider.URL
+		if source == "" {
+			source = provider.Path
+		}
+		if provider.Behavior == "" || source == "" {
+			errs = append(errs, config.Errorf("Rule Provider", name, "missing behavior or source"))
+			continue
+		}
+		if provider.Interval < 0 {
+			errs = append(errs, config.Errorf("Rule Provider", name, "invalid interval %d", provider.Interval))
+			continue
+		}
+		config.RuleProviders = append(config.RuleProviders, RuleProvider{
+			Name:     name,
+			Behavior: provider.Behavior,
+			Source:   source,
+			Interval: provider.Interval,
+		})
+	}
+
+	for _, line := range raw.Rules {
+		rule, err := parseRule(line)
+		if err != nil {
+			errs = append(errs, config.Errorf("Rule", line, "%s", err.Error()))
+			continue
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+
+	for _, tunnel := range raw.Tunnels {
+		address, err := tunnelAddress(tunnel.Address)
+		if err != nil {
+			errs = append(errs, config.Errorf("Tunnel", tunnel.Name, "%s", err.Error()))
+			continue
+		}
+		config.Tunnels = append(config.Tunnels, Tunnel{
+			Name:    tunnel.Name,
+			Network: tunnel.Network,
+			Address: address,
+			Target:  tunnel.Target,
+			Proxy:   tunnel.Proxy,
+		})
+	}
+
+	// user:password
+	for _, user := range raw.Authentication {
+		pair := strings.SplitN(user, ":", 2)
+		if len(pair) != 2 {
+			errs = append(errs, config.Errorf("Authentication", pair[0], "expect user:password"))
+			continue
+		}
+		config.Users[pair[0]] = pair[1]
+	}
+
+	config.Sniffer = Sniffer{
+		TLSPorts:   itoaArr(raw.Sniffer.TLSPorts),
+		HTTPPorts:  itoaArr(raw.Sniffer.HTTPPorts),
+		SkipDomain: raw.Sniffer.SkipDomain,
+	}
+
+	errs = append(errs, config.checkSniffer()...)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+func itoaArr(arr []int) (r []string) {
+	for _, e := range arr {
+		r = append(r, strconv.Itoa(e))
+	}
+	return
+}
@@ -17,6 +17,19 @@ type ServerAdapter interface {
 	Close()
 }
 
+// ProxyServerAdapter dials through the matched proxy by itself,
+// so that it can reuse upstream connections
+type ProxyServerAdapter interface {
+	ServerAdapter
+	ConnectProxy(Proxy)
+}
+
+// SpecifiedServerAdapter skips the rules and always uses the named proxy
+type SpecifiedServerAdapter interface {
+	ServerAdapter
+	ProxyName() string
+}
+
 type Proxy interface {
 	Name() string
 	Generator(addr *Addr) (ProxyAdapter, error)
@@ -30,6 +30,7 @@ type Addr struct {
 	Host     string
 	IP       *net.IP
 	Port     string
+	User     string
 }
 
 func (addr *Addr) String() string {
@@ -1,112 +1,74 @@
 package constant
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"io"
-	"net/http"
+	"fmt"
 	"os"
 	"os/user"
 	"path"
-	"strings"
+	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/ini.v1"
 )
 
 const (
 	Name             = "clash"
-	DefalutHTTPPort  = "7890"
-	DefalutSOCKSPort = "7891"
+	DefalutHTTPPort  = 7890
+	DefalutSOCKSPort = 7891
 )
 
 var (
-	HomeDir    string
-	ConfigPath string
-	MMDBPath   string
+	HomeDir     string
+	Path        string
+	ConfigPath  string
+	MMDBPath    string
+	ASNPath     string
+	GeoSitePath string
 )
 
-func init() {
-	currentUser, err := user.Current()
-	if err != nil {
-		dir := os.Getenv("HOME")
-		if dir == "" {
-			log.Fatalf("Can't get current user: %s", err.Error())
-		}
-		HomeDir = dir
-	} else {
+// DefaultHomeDir is $HOME/.config/clash
+func DefaultHomeDir() (string, error) {
+	if currentUser, err := user.Current(); err == nil {
 		HomeDir = currentUser.HomeDir
+	} else if HomeDir = os.Getenv("HOME"); HomeDir == "" {
+		return "", fmt.Errorf("can't get current user: %s", err.Error())
 	}
+	return path.Join(HomeDir, ".config", Name), nil
+}
 
-	dirPath := path.Join(HomeDir, ".config", Name)
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(dirPath, 0777); err != nil {
-			log.Fatalf("Can't create config directory %s: %s", dirPath, err.Error())
-		}
+// Init creates the home dir set by SetHomeDir, and an empty config in it
+// when there is none
+func Init() error {
+	if err := os.MkdirAll(Path, 0777); err != nil {
+		return fmt.Errorf("can't create config directory %s: %s", Path, err.Error())
 	}
 
-	ConfigPath = path.Join(dirPath, "config.ini")
-	if _, err := os.Stat(ConfigPath); os.IsNotExist(err) {
+	if _, err := os.Stat(ConfigPath); os.IsNotExist(err) && filepath.Dir(ConfigPath) == filepath.Clean(Path) {
 		log.Info("Can't find config, create a empty file")
-		os.OpenFile(ConfigPath, os.O_CREATE|os.O_WRONLY, 0644)
-	}
-
-	MMDBPath = path.Join(dirPath, "Country.mmdb")
-	if _, err := os.Stat(MMDBPath); os.IsNotExist(err) {
-		log.Info("Can't find MMDB, start download")
-		err := downloadMMDB(MMDBPath)
+		f, err := os.OpenFile(ConfigPath, os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			log.Fatalf("Can't download MMDB: %s", err.Error())
+			return err
 		}
+		f.Close()
 	}
+	return nil
 }
 
-func downloadMMDB(path string) (err error) {
-	resp, err := http.Get("http://geolite.maxmind.com/download/geoip/database/GeoLite2-Country.tar.gz")
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-
-	gr, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return
-	}
-	defer gr.Close()
-
-	tr := tar.NewReader(gr)
-	for {
-		h, err := tr.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-
-		if !strings.HasSuffix(h.Name, "GeoLite2-Country.mmdb") {
-			continue
-		}
+// SetHomeDir points all paths into dir
+func SetHomeDir(dir string) {
+	Path = dir
 
-		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		_, err = io.Copy(f, tr)
-		if err != nil {
-			return err
-		}
+	// config.yaml takes precedence, config.ini is kept for migration
+	ConfigPath = path.Join(dir, "config.yaml")
+	if _, err := os.Stat(ConfigPath); os.IsNotExist(err) {
+		ConfigPath = path.Join(dir, "config.ini")
 	}
 
-	return nil
+	MMDBPath = path.Join(dir, "Country.mmdb")
+	ASNPath = path.Join(dir, "GeoLite2-ASN.mmdb")
+	GeoSitePath = path.Join(dir, "GeoSite.dat")
 }
 
-func GetConfig() (*ini.File, error) {
-	if _, err := os.Stat(ConfigPath); os.IsNotExist(err) {
-		return nil, err
-	}
-	return ini.LoadSources(
-		ini.LoadOptions{AllowBooleanKeys: true},
-		ConfigPath,
-	)
+// SetConfig overrides the config file found in the home dir
+func SetConfig(file string) {
+	ConfigPath = file
 }
@@ -5,7 +5,15 @@ const (
 	DomainSuffix RuleType = iota
 	DomainKeyword
 	GEOIP
+	GEOSITE
+	IPASN
 	IPCIDR
+	DstPort
+	User
+	RuleSet
+	AND
+	OR
+	NOT
 	FINAL
 )
 
@@ -19,8 +27,24 @@ func (rt RuleType) String() string {
 		return "DomainKeyword"
 	case GEOIP:
 		return "GEOIP"
+	case GEOSITE:
+		return "GEOSITE"
+	case IPASN:
+		return "IPASN"
 	case IPCIDR:
 		return "IPCIDR"
+	case DstPort:
+		return "DstPort"
+	case User:
+		return "User"
+	case RuleSet:
+		return "RuleSet"
+	case AND:
+		return "AND"
+	case OR:
+		return "OR"
+	case NOT:
+		return "NOT"
 	case FINAL:
 		return "FINAL"
 	default:
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"./convert"
+)
+
+// runConvert implements `clash convert [-o config.ini] file...`,
+// every file is a Surge config or a list of ss:// links
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	output := flags.String("o", "", "write config.ini to this path instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: clash convert [-o config.ini] file...")
+		fmt.Fprintln(os.Stderr, "Converts Surge configs and ss:// links, - reads stdin.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	result := convert.NewResult()
+	for _, path := range flags.Args() {
+		var (
+			buf []byte
+			err error
+		)
+		if path == "-" {
+			buf, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			buf, err = ioutil.ReadFile(path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Read %s error: %s\n", path, err.Error())
+			return 1
+		}
+
+		if bytes.Contains(buf, []byte("[Proxy")) || bytes.Contains(buf, []byte("[Rule]")) {
+			err = result.Surge(buf)
+		} else {
+			result.SSLinks(buf)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Convert %s error: %s\n", path, err.Error())
+			return 1
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Write %s error: %s\n", *output, err.Error())
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := result.WriteINI(w); err != nil {
+		fmt.Fprintf(os.Stderr, "Write config error: %s\n", err.Error())
+		return 1
+	}
+
+	for _, issue := range result.Issues {
+		fmt.Fprintf(os.Stderr, "not converted: %s\n", issue)
+	}
+	fmt.Fprintf(os.Stderr, "%d proxies, %d proxy groups, %d rules converted, %d issues\n",
+		len(result.Proxies), len(result.ProxyGroups), len(result.Rules), len(result.Issues))
+	return 0
+}
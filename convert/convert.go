@@ -0,0 +1,134 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"../config"
+)
+
+// Result is the converted configuration, Issues lists everything that
+// could not be translated
+type Result struct {
+	Proxies       []config.Proxy
+	ProxyGroups   []config.ProxyGroup
+	RuleProviders []config.RuleProvider
+	Rules         []config.Rule
+	Issues        []string
+
+	// policies maps a policy name of the source to its name in the result
+	policies map[string]string
+	names    map[string]bool
+}
+
+func NewResult() *Result {
+	return &Result{
+		policies: map[string]string{
+			"DIRECT":         "DIRECT",
+			"REJECT":         "REJECT",
+			"REJECT-TINYGIF": "REJECT",
+			"REJECT-DROP":    "REJECT",
+			"REJECT-NO-DROP": "REJECT",
+		},
+		names: map[string]bool{
+			"DIRECT": true,
+			"REJECT": true,
+		},
+	}
+}
+
+func (r *Result) report(format string, args ...interface{}) {
+	r.Issues = append(r.Issues, fmt.Sprintf(format, args...))
+}
+
+// name returns a unique name that can be written to the INI format,
+// commas separate the fields of a value so they can't appear in a name
+func (r *Result) name(name string) string {
+	name = strings.TrimSpace(strings.NewReplacer(",", " ", "`", "").Replace(name))
+	if name == "" {
+		name = "Proxy"
+	}
+
+	unique := name
+	for i := 2; r.names[unique]; i++ {
+		unique = fmt.Sprintf("%s %d", name, i)
+	}
+	if unique != name {
+		r.report("%s is renamed to %s", name, unique)
+	}
+	r.names[unique] = true
+	return unique
+}
+
+func (r *Result) addProxy(source string, proxy config.Proxy) {
+	if !validField(proxy.Password) {
+		r.report("Proxy %s: the password is empty or can't be written to config.ini", source)
+		return
+	}
+
+	proxy.Name = r.name(proxy.Name)
+	r.policies[source] = proxy.Name
+	r.Proxies = append(r.Proxies, proxy)
+}
+
+func (r *Result) addGroup(source string, group config.ProxyGroup) {
+	// groups only see the proxies and groups defined before them
+	proxies := []string{}
+	for _, member := range group.Proxies {
+		name, ok := r.policies[member]
+		if !ok || name == "DIRECT" || name == "REJECT" {
+			r.report("Proxy Group %s: member %s is dropped", source, member)
+			continue
+		}
+		proxies = append(proxies, name)
+	}
+	if len(proxies) == 0 {
+		r.report("Proxy Group %s: no member left", source)
+		return
+	}
+
+	group.Name = r.name(group.Name)
+	group.Proxies = proxies
+	r.policies[source] = group.Name
+	r.ProxyGroups = append(r.ProxyGroups, group)
+}
+
+// addRule is called after all proxies and groups are added
+func (r *Result) addRule(line string, rule config.Rule) {
+	target, ok := r.policies[rule.Target]
+	if !ok {
+		r.report("Rule %s: policy %s is not converted", line, rule.Target)
+		return
+	}
+
+	rule.Target = target
+	r.Rules = append(r.Rules, rule)
+}
+
+// addProvider returns the name of a classical rule provider fetching url
+func (r *Result) addProvider(url string) string {
+	for _, provider := range r.RuleProviders {
+		if provider.Source == url {
+			return provider.Name
+		}
+	}
+
+	name := url[strings.LastIndex(url, "/")+1:]
+	if idx := strings.IndexAny(name, ".?#"); idx != -1 {
+		name = name[:idx]
+	}
+	name = r.name(name)
+	r.RuleProviders = append(r.RuleProviders, config.RuleProvider{
+		Name:     name,
+		Behavior: "classical",
+		Source:   url,
+		Interval: 86400,
+	})
+	r.report("Rule provider %s: entries of %s are not checked, unsupported rule types fail to load", name, url)
+	return name
+}
+
+// validField reports whether s survives the comma separated INI value
+func validField(s string) bool {
+	return s != "" && s == strings.TrimSpace(s) && !strings.ContainsAny(s, ",`\r\n")
+}
@@ -0,0 +1,122 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"../config"
+)
+
+const surgeConfig = `
+[General]
+loglevel = notify
+
+[Proxy]
+HK = ss, hk.example.com, 8388, encrypt-method=chacha20-ietf-poly1305, password=p#ss, udp-relay=true
+JP = custom, jp.example.com, 443, aes-128-gcm, secret, https://example.com/SSEncrypt.module
+US = vmess, us.example.com, 443, username=uuid
+Obfs = ss, obfs.example.com, 443, encrypt-method=aes-256-gcm, password=secret, obfs=tls
+LAN = direct
+
+[Proxy Group]
+Auto = url-test, HK, JP, US, url=http://www.gstatic.com/generate_204, interval=300
+Select = select, Auto, DIRECT
+
+[Rule]
+DOMAIN-SUFFIX,google.com,Auto
+DOMAIN,www.example.com,Auto
+IP-CIDR6,2001:db8::/32,LAN,no-resolve
+AND,((DOMAIN-KEYWORD,youtube),(DEST-PORT,443)),HK
+RULE-SET,https://example.com/rules/Apple.list,JP
+DOMAIN-KEYWORD,ads,REJECT-TINYGIF
+GEOIP,CN,Select
+FINAL,Auto,dns-failed
+`
+
+func TestSurge(t *testing.T) {
+	r := NewResult()
+	if err := r.Surge([]byte(surgeConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Proxies) != 2 || r.Proxies[0].Cipher != "AEAD_CHACHA20_POLY1305" || r.Proxies[1].Password != "secret" {
+		t.Fatalf("unexpected proxies %+v", r.Proxies)
+	}
+	if len(r.ProxyGroups) != 1 || !reflect.DeepEqual(r.ProxyGroups[0].Proxies, []string{"HK", "JP"}) {
+		t.Fatalf("unexpected groups %+v", r.ProxyGroups)
+	}
+
+	expected := []string{
+		"DOMAIN-SUFFIX,google.com,Auto",
+		"IP-CIDR6,2001:db8::/32,DIRECT",
+		"AND,((DOMAIN-KEYWORD,youtube),(DST-PORT,443)),HK",
+		"RULE-SET,Apple,JP",
+		"DOMAIN-KEYWORD,ads,REJECT",
+		"FINAL,,Auto",
+	}
+	rules := []string{}
+	for _, rule := range r.Rules {
+		rules = append(rules, rule.Type+","+rule.Payload+","+rule.Target)
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+
+	// everything dropped is reported
+	for _, issue := range []string{"[General]", "vmess", "obfs", "member US", "select", "DOMAIN,www.example.com", "policy Select", "option no-resolve", "option dns-failed"} {
+		found := false
+		for _, reported := range r.Issues {
+			found = found || strings.Contains(reported, issue)
+		}
+		if !found {
+			t.Errorf("%s is not reported in %v", issue, r.Issues)
+		}
+	}
+}
+
+func TestSSLinks(t *testing.T) {
+	sip002 := "ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:pass,word")) + "@1.2.3.4:8388#Tokyo%201"
+	legacy := "ss://" + base64.StdEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:secret@[::1]:443")) + "#Tokyo 1"
+	plugin := "ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:secret")) + "@1.2.3.4:443/?plugin=obfs-local#Obfs"
+	links := strings.Join([]string{sip002, legacy, plugin, "vmess://abc"}, "\n")
+
+	// subscriptions are a base64 encoded list
+	r := NewResult()
+	r.SSLinks([]byte(base64.StdEncoding.EncodeToString([]byte(links))))
+
+	if len(r.Proxies) != 1 {
+		t.Fatalf("unexpected proxies %+v", r.Proxies)
+	}
+	proxy := r.Proxies[0]
+	if proxy.Name != "Tokyo 1" || proxy.Server != "::1" || proxy.Port != 443 || proxy.Cipher != "AEAD_CHACHA20_POLY1305" {
+		t.Fatalf("unexpected proxy %+v", proxy)
+	}
+
+	// the comma in the password, the plugin and vmess
+	if len(r.Issues) != 3 {
+		t.Fatalf("unexpected issues %v", r.Issues)
+	}
+}
+
+func TestWriteINI(t *testing.T) {
+	r := NewResult()
+	r.Surge([]byte(surgeConfig))
+	r.SSLinks([]byte("ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-128-gcm:secret")) + "@5.6.7.8:443"))
+
+	buf := &bytes.Buffer{}
+	if err := r.WriteINI(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.ParseINI(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Proxies, r.Proxies) || !reflect.DeepEqual(cfg.ProxyGroups, r.ProxyGroups) ||
+		!reflect.DeepEqual(cfg.RuleProviders, r.RuleProviders) || !reflect.DeepEqual(cfg.Rules, r.Rules) {
+		t.Fatalf("config.ini is read differently:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,61 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteINI writes the result in the layout read by config.ParseINI,
+// the issues are kept as comments at the top
+func (r *Result) WriteINI(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+
+	if len(r.Issues) > 0 {
+		fmt.Fprintln(buf, "# not converted:")
+		for _, issue := range r.Issues {
+			fmt.Fprintf(buf, "# %s\n", strings.Replace(issue, "\n", " ", -1))
+		}
+		fmt.Fprintln(buf)
+	}
+
+	fmt.Fprintln(buf, "[Proxy]")
+	for _, proxy := range r.Proxies {
+		writeKey(buf, proxy.Name, proxy.Type, proxy.Server, strconv.Itoa(proxy.Port), proxy.Cipher, proxy.Password)
+	}
+
+	fmt.Fprintln(buf, "\n[Proxy Group]")
+	for _, group := range r.ProxyGroups {
+		fields := append([]string{group.Type}, group.Proxies...)
+		writeKey(buf, group.Name, append(fields, group.URL, strconv.Itoa(group.Delay))...)
+	}
+
+	if len(r.RuleProviders) > 0 {
+		fmt.Fprintln(buf, "\n[Rule Provider]")
+		for _, provider := range r.RuleProviders {
+			writeKey(buf, provider.Name, provider.Behavior, provider.Source, strconv.Itoa(provider.Interval))
+		}
+	}
+
+	fmt.Fprintln(buf, "\n[Rule]")
+	for _, rule := range r.Rules {
+		fmt.Fprintln(buf, quote(strings.Join([]string{rule.Type, rule.Payload, rule.Target}, ","), "=:#;"))
+	}
+
+	return buf.Flush()
+}
+
+func writeKey(w io.Writer, name string, fields ...string) {
+	fmt.Fprintf(w, "%s = %s\n", quote(name, "=:#;[\""), quote(strings.Join(fields, ", "), "#;"))
+}
+
+// quote wraps s in backquotes when it contains one of chars,
+// otherwise ini reads them as a delimiter or a comment
+func quote(s string, chars string) string {
+	if strings.ContainsAny(s, chars) {
+		return "`" + s + "`"
+	}
+	return s
+}
@@ -0,0 +1,167 @@
+package convert
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"../config"
+)
+
+// ciphers maps the lower case names used by Surge and ss:// links to go-shadowsocks2
+var ciphers = map[string]string{
+	"aes-128-gcm":            "AEAD_AES_128_GCM",
+	"aes-192-gcm":            "AEAD_AES_192_GCM",
+	"aes-256-gcm":            "AEAD_AES_256_GCM",
+	"chacha20-ietf-poly1305": "AEAD_CHACHA20_POLY1305",
+	"aes-128-ctr":            "AES-128-CTR",
+	"aes-192-ctr":            "AES-192-CTR",
+	"aes-256-ctr":            "AES-256-CTR",
+	"aes-128-cfb":            "AES-128-CFB",
+	"aes-192-cfb":            "AES-192-CFB",
+	"aes-256-cfb":            "AES-256-CFB",
+	"chacha20-ietf":          "CHACHA20-IETF",
+	"xchacha20":              "XCHACHA20",
+}
+
+func ssCipher(method string) (string, bool) {
+	method = strings.TrimSpace(method)
+	if cipher, ok := ciphers[strings.ToLower(method)]; ok {
+		return cipher, true
+	}
+	for _, cipher := range ciphers {
+		if strings.EqualFold(method, cipher) {
+			return cipher, true
+		}
+	}
+	return "", false
+}
+
+// SSLinks converts ss:// links, one per line,
+// a base64 encoded list of links is accepted as well
+func (r *Result) SSLinks(buf []byte) {
+	text := string(buf)
+	if !strings.Contains(text, "://") {
+		if decoded, err := decodeBase64(strings.Join(strings.Fields(text), "")); err == nil {
+			text = string(decoded)
+		}
+	}
+
+	for _, link := range strings.Split(text, "\n") {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		if !strings.HasPrefix(link, "ss://") {
+			scheme := link
+			if idx := strings.Index(link, "://"); idx != -1 {
+				scheme = link[:idx]
+			}
+			r.report("Link %s: scheme %s is not supported", shorten(link), scheme)
+			continue
+		}
+
+		proxy, err := parseSSLink(link)
+		if err != nil {
+			r.report("Link %s: %s", shorten(link), err.Error())
+			continue
+		}
+		r.addProxy(proxy.Name, proxy)
+	}
+}
+
+// parseSSLink accepts SIP002 ss://base64(method:password)@server:port#name
+// and the legacy ss://base64(method:password@server:port)#name
+func parseSSLink(link string) (config.Proxy, error) {
+	proxy := config.Proxy{Type: "ss"}
+
+	body := strings.TrimPrefix(link, "ss://")
+	if idx := strings.Index(body, "#"); idx != -1 {
+		name, err := url.PathUnescape(body[idx+1:])
+		if err != nil {
+			return proxy, fmt.Errorf("invalid name %s", body[idx+1:])
+		}
+		proxy.Name, body = name, body[:idx]
+	}
+
+	var userinfo, hostport string
+	if idx := strings.LastIndex(body, "@"); idx != -1 {
+		u, err := url.Parse("ss://" + body)
+		if err != nil {
+			return proxy, err
+		}
+		if plugin := u.Query().Get("plugin"); plugin != "" {
+			return proxy, fmt.Errorf("plugin %s is not supported", plugin)
+		}
+
+		hostport = u.Host
+		if password, ok := u.User.Password(); ok {
+			userinfo = u.User.Username() + ":" + password
+		} else {
+			decoded, err := decodeBase64(u.User.Username())
+			if err != nil {
+				return proxy, fmt.Errorf("invalid user info")
+			}
+			userinfo = string(decoded)
+		}
+	} else {
+		decoded, err := decodeBase64(body)
+		if err != nil {
+			return proxy, fmt.Errorf("invalid base64")
+		}
+		idx := strings.LastIndex(string(decoded), "@")
+		if idx == -1 {
+			return proxy, fmt.Errorf("missing server")
+		}
+		userinfo, hostport = string(decoded[:idx]), string(decoded[idx+1:])
+	}
+
+	pair := strings.SplitN(userinfo, ":", 2)
+	if len(pair) != 2 {
+		return proxy, fmt.Errorf("missing method or password")
+	}
+	cipher, ok := ssCipher(pair[0])
+	if !ok {
+		return proxy, fmt.Errorf("cipher %s is not supported", pair[0])
+	}
+	proxy.Cipher, proxy.Password = cipher, pair[1]
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return proxy, err
+	}
+	if proxy.Port, err = strconv.Atoi(port); err != nil || proxy.Port <= 0 || proxy.Port > 65535 {
+		return proxy, fmt.Errorf("invalid port %s", port)
+	}
+	proxy.Server = host
+
+	if proxy.Name == "" {
+		proxy.Name = hostport
+	}
+	return proxy, nil
+}
+
+// decodeBase64 accepts the standard and url alphabets, padded or not
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if buf, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return buf, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// shorten keeps secrets of a link out of the report
+func shorten(link string) string {
+	if idx := strings.Index(link, "#"); idx != -1 {
+		if name, err := url.PathUnescape(link[idx+1:]); err == nil {
+			return name
+		}
+	}
+	if len(link) > 16 {
+		return link[:16] + "..."
+	}
+	return link
+}
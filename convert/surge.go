@@ -0,0 +1,330 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"../config"
+)
+
+const defaultTestURL = "http://www.gstatic.com/generate_204"
+
+type surgeLine struct {
+	section string
+	text    string
+}
+
+// Surge converts the [Proxy], [Proxy Group] and [Rule] sections of a Surge config
+func (r *Result) Surge(buf []byte) error {
+	var (
+		lines   []surgeLine
+		section string
+		ignored = map[string]bool{}
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "", strings.HasPrefix(text, "#"), strings.HasPrefix(text, ";"), strings.HasPrefix(text, "//"):
+			continue
+		case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+
+		switch section {
+		case "Proxy", "Proxy Group", "Rule":
+			lines = append(lines, surgeLine{section, text})
+		default:
+			if !ignored[section] {
+				ignored[section] = true
+				r.report("[%s] is not converted", section)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// rules may refer to any proxy or group, convert them first
+	for _, line := range lines {
+		switch line.section {
+		case "Proxy":
+			r.surgeProxy(line.text)
+		case "Proxy Group":
+			r.surgeGroup(line.text)
+		}
+	}
+	for _, line := range lines {
+		if line.section == "Rule" {
+			r.surgeRule(line.text)
+		}
+	}
+	return nil
+}
+
+// splitSurge splits name = value, the fields of value and its key=value params
+func splitSurge(text string) (name string, fields []string, params map[string]string, ok bool) {
+	idx := strings.Index(text, "=")
+	if idx == -1 {
+		return
+	}
+
+	name = strings.TrimSpace(text[:idx])
+	params = map[string]string{}
+	for _, field := range strings.Split(text[idx+1:], ",") {
+		field = strings.TrimSpace(field)
+		if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+			params[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return name, fields, params, name != "" && len(fields) > 0
+}
+
+// name = ss, server, port, encrypt-method=method, password=password
+// name = custom, server, port, method, password, module
+func (r *Result) surgeProxy(text string) {
+	name, fields, params, ok := splitSurge(text)
+	if !ok {
+		r.report("Proxy %s: invalid line", text)
+		return
+	}
+
+	tp := strings.ToLower(fields[0])
+	switch tp {
+	case "direct":
+		r.policies[name] = "DIRECT"
+		return
+	case "reject", "reject-tinygif", "reject-drop", "reject-no-drop":
+		r.policies[name] = "REJECT"
+		return
+	case "ss":
+		if len(fields) < 3 {
+			r.report("Proxy %s: missing server or port", name)
+			return
+		}
+	case "custom":
+		if len(fields) < 5 {
+			r.report("Proxy %s: missing server, port, method or password", name)
+			return
+		}
+		params["encrypt-method"], params["password"] = fields[3], fields[4]
+	default:
+		r.report("Proxy %s: type %s is not supported", name, fields[0])
+		return
+	}
+
+	if obfs := params["obfs"]; obfs != "" {
+		r.report("Proxy %s: obfs %s is not supported", name, obfs)
+		return
+	}
+
+	port, err := strconv.Atoi(fields[2])
+	if err != nil || port <= 0 || port > 65535 {
+		r.report("Proxy %s: invalid port %s", name, fields[2])
+		return
+	}
+
+	cipher, ok := ssCipher(params["encrypt-method"])
+	if !ok {
+		r.report("Proxy %s: cipher %s is not supported", name, params["encrypt-method"])
+		return
+	}
+
+	r.addProxy(name, config.Proxy{
+		Name:     name,
+		Type:     "ss",
+		Server:   fields[1],
+		Port:     port,
+		Cipher:   cipher,
+		Password: params["password"],
+	})
+}
+
+// name = url-test, proxy1, proxy2, url=url, interval=second
+func (r *Result) surgeGroup(text string) {
+	name, fields, params, ok := splitSurge(text)
+	if !ok {
+		r.report("Proxy Group %s: invalid line", text)
+		return
+	}
+
+	if fields[0] != "url-test" {
+		r.report("Proxy Group %s: type %s is not supported", name, fields[0])
+		return
+	}
+	if path := params["policy-path"]; path != "" {
+		r.report("Proxy Group %s: policy-path %s is not supported", name, path)
+	}
+
+	url := params["url"]
+	if url == "" {
+		url = defaultTestURL
+	}
+	delay := 600
+	if interval, ok := params["interval"]; ok {
+		var err error
+		if delay, err = strconv.Atoi(interval); err != nil || delay <= 0 {
+			r.report("Proxy Group %s: invalid interval %s", name, interval)
+			return
+		}
+	}
+
+	r.addGroup(name, config.ProxyGroup{
+		Name:    name,
+		Type:    "url-test",
+		Proxies: fields[1:],
+		URL:     url,
+		Delay:   delay,
+	})
+}
+
+// TYPE,VALUE,POLICY[,options], logic rules wrap their value in parentheses
+func (r *Result) surgeRule(text string) {
+	idx := strings.Index(text, ",")
+	if idx == -1 {
+		r.report("Rule %s: invalid line", text)
+		return
+	}
+	tp, rest := strings.ToUpper(strings.TrimSpace(text[:idx])), strings.TrimSpace(text[idx+1:])
+
+	var value string
+	switch tp {
+	case "FINAL":
+		value = ""
+	case "AND", "OR", "NOT":
+		end := closingParen(rest)
+		if end == -1 {
+			r.report("Rule %s: unbalanced parentheses", text)
+			return
+		}
+		value, rest = rest[:end+1], strings.TrimPrefix(strings.TrimSpace(rest[end+1:]), ",")
+	default:
+		idx := strings.Index(rest, ",")
+		if idx == -1 {
+			r.report("Rule %s: missing policy", text)
+			return
+		}
+		value, rest = strings.TrimSpace(rest[:idx]), rest[idx+1:]
+	}
+
+	// options such as no-resolve follow the policy, clash has none of them
+	fields := strings.Split(rest, ",")
+	policy := strings.TrimSpace(fields[0])
+	if policy == "" {
+		r.report("Rule %s: missing policy", text)
+		return
+	}
+
+	rule, err := r.surgeMatcher(tp, value)
+	if err != nil {
+		r.report("Rule %s: %s", text, err.Error())
+		return
+	}
+	rule.Target = policy
+	r.addRule(text, rule)
+	for _, option := range fields[1:] {
+		if option = strings.TrimSpace(option); option != "" {
+			r.report("Rule %s: option %s dropped", text, option)
+		}
+	}
+}
+
+func (r *Result) surgeMatcher(tp string, value string) (config.Rule, error) {
+	rule := config.Rule{Type: tp, Payload: value}
+	switch tp {
+	case "DOMAIN-SUFFIX", "DOMAIN-KEYWORD", "GEOIP", "FINAL":
+	case "IP-CIDR", "IP-CIDR6":
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return rule, fmt.Errorf("invalid CIDR %s", value)
+		}
+	case "IP-ASN":
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return rule, fmt.Errorf("invalid ASN %s", value)
+		}
+	case "DEST-PORT", "DST-PORT":
+		if port, err := strconv.Atoi(value); err != nil || port < 0 || port > 65535 {
+			return rule, fmt.Errorf("port %s is not supported", value)
+		}
+		rule.Type = "DST-PORT"
+	case "RULE-SET":
+		if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			return rule, fmt.Errorf("rule set %s is not supported", value)
+		}
+		rule.Payload = r.addProvider(value)
+	case "AND", "OR", "NOT":
+		payload, err := r.surgeLogic(value)
+		if err != nil {
+			return rule, err
+		}
+		rule.Payload = payload
+	default:
+		return rule, fmt.Errorf("type %s is not supported", tp)
+	}
+	return rule, nil
+}
+
+// surgeLogic converts every sub-rule of ((TYPE,VALUE),(TYPE,VALUE))
+func (r *Result) surgeLogic(value string) (string, error) {
+	if len(value) < 2 || value[0] != '(' || value[len(value)-1] != ')' {
+		return "", fmt.Errorf("%s should be wrapped in parentheses", value)
+	}
+
+	items := []string{}
+	inner := value[1 : len(value)-1]
+	for len(strings.TrimSpace(inner)) > 0 {
+		inner = strings.TrimSpace(inner)
+		end := closingParen(inner)
+		if inner[0] != '(' || end == -1 {
+			return "", fmt.Errorf("invalid sub-rule %s", inner)
+		}
+
+		body := inner[1:end]
+		inner = strings.TrimPrefix(strings.TrimSpace(inner[end+1:]), ",")
+
+		tp, payload := body, ""
+		if idx := strings.Index(body, ","); idx != -1 {
+			tp, payload = body[:idx], body[idx+1:]
+		}
+		tp, payload = strings.ToUpper(strings.TrimSpace(tp)), strings.TrimSpace(payload)
+		if tp == "FINAL" {
+			return "", fmt.Errorf("FINAL is not allowed in a logic rule")
+		}
+
+		rule, err := r.surgeMatcher(tp, payload)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, fmt.Sprintf("(%s,%s)", rule.Type, rule.Payload))
+	}
+	if len(items) == 0 {
+		return "", fmt.Errorf("missing sub-rules")
+	}
+	return "(" + strings.Join(items, ",") + ")", nil
+}
+
+// closingParen returns the index of the parenthesis closing s[0]
+func closingParen(s string) int {
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+		if depth == 0 {
+			return -1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package dialer
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"syscall"
+)
+
+func bind(iface string, mark int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var innerErr error
+		err := c.Control(func(fd uintptr) {
+			if iface != "" {
+				if innerErr = syscall.BindToDevice(int(fd), iface); innerErr != nil {
+					return
+				}
+			}
+			if mark != 0 {
+				innerErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return innerErr
+	}
+}
+
+// DefaultInterface finds the interface of the IPv4 default route other than exclude,
+// it's empty when there is none
+func DefaultInterface(exclude string) string {
+	buf, err := ioutil.ReadFile("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	return defaultRoute(buf, exclude)
+}
+
+// defaultRoute reads the format of /proc/net/route, the destination of default routes is 00000000
+func defaultRoute(buf []byte, exclude string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 2 && fields[1] == "00000000" && fields[0] != exclude {
+			return fields[0]
+		}
+	}
+	return ""
+}
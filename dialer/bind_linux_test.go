@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package dialer
+
+import "testing"
+
+func TestDefaultRoute(t *testing.T) {
+	routes := []byte(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+clash0	00000000	00000000	0001	0	0	0	00000000	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`)
+	if iface := defaultRoute(routes, "clash0"); iface != "eth0" {
+		t.Fatalf("expect eth0, got %q", iface)
+	}
+	if iface := defaultRoute(routes[:0], "clash0"); iface != "" {
+		t.Fatalf("expect no interface, got %q", iface)
+	}
+}
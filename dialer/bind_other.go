@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package dialer
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bind(iface string, mark int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.New("interface-name and routing-mark are only supported on linux")
+	}
+}
+
+// DefaultInterface is only supported on linux
+func DefaultInterface(exclude string) string {
+	return ""
+}
@@ -0,0 +1,96 @@
+// Package dialer opens the outbound sockets of clash, they can be bound to
+// an interface or marked so they don't loop back into a TUN device holding
+// the default route
+package dialer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+
+var (
+	interfaceName string
+	routingMark   int
+	lock          sync.RWMutex
+)
+
+// Set changes the interface and the routing mark of new sockets, empty and 0 disable them
+func Set(iface string, mark int) {
+	lock.Lock()
+	defer lock.Unlock()
+	interfaceName, routingMark = iface, mark
+}
+
+func options() (string, int) {
+	lock.RLock()
+	defer lock.RUnlock()
+	return interfaceName, routingMark
+}
+
+func dialer() *net.Dialer {
+	iface, mark := options()
+	d := &net.Dialer{Timeout: dialTimeout}
+	if iface != "" || mark != 0 {
+		d.Control = bind(iface, mark)
+		// resolve through the bound sockets as well
+		d.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return (&net.Dialer{Control: bind(iface, mark)}).DialContext(ctx, network, address)
+			},
+		}
+	}
+	return d
+}
+
+// Dial is net.Dial through the configured interface and mark
+func Dial(network, address string) (net.Conn, error) {
+	return dialer().Dial(network, address)
+}
+
+// ListenPacket is net.ListenPacket through the configured interface and mark
+func ListenPacket(network, address string) (net.PacketConn, error) {
+	iface, mark := options()
+	lc := &net.ListenConfig{}
+	if iface != "" || mark != 0 {
+		lc.Control = bind(iface, mark)
+	}
+	return lc.ListenPacket(context.Background(), network, address)
+}
+
+// Resolver queries the system DNS servers through the configured interface and mark
+func Resolver() *net.Resolver {
+	if d := dialer(); d.Resolver != nil {
+		return d.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// ResolveUDPAddr is net.ResolveUDPAddr through Resolver
+func ResolveUDPAddr(address string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	portnum, err := Resolver().LookupPort(ctx, "udp", port)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: portnum}, nil
+	}
+
+	ips, err := Resolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ips[0].IP, Port: portnum, Zone: ips[0].Zone}, nil
+}
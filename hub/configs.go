@@ -1,7 +1,20 @@
 package hub
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"strings"
+
+	"../config"
+	C "../constant"
+	P "../proxy"
+	R "../rules"
+	"./executor"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
@@ -10,6 +23,33 @@ import (
 type Configs struct {
 	Proxys []Proxy `json:"proxys"`
 	Rules  []Rule  `json:"rules"`
+	Ports  P.Ports `json:"ports"`
+}
+
+type PatchConfigs struct {
+	Port       *int `json:"port"`
+	SocksPort  *int `json:"socks-port"`
+	MixedPort  *int `json:"mixed-port"`
+	RedirPort  *int `json:"redir-port"`
+	TProxyPort *int `json:"tproxy-port"`
+	SSPort     *int `json:"ss-port"`
+}
+
+// UpdateConfigs replaces the running config with Payload or the config at Path,
+// an empty body reloads the config file
+type UpdateConfigs struct {
+	Path    string `json:"path"`
+	Payload string `json:"payload"`
+	// Format of Payload, "ini" or "yaml", defaults to the format of the config file
+	Format string `json:"format"`
+	// Save writes the config to the config file once it's applied
+	Save bool `json:"save"`
+}
+
+// ConfigError lists every problem of a config that failed to load
+type ConfigError struct {
+	Error  string        `json:"error"`
+	Errors config.Errors `json:"errors"`
 }
 
 type Proxy struct {
@@ -19,12 +59,14 @@ type Proxy struct {
 type Rule struct {
 	Name    string `json:"name"`
 	Payload string `json:"type"`
+	Rules   []Rule `json:"rules,omitempty"`
 }
 
 func configRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/", getConfig)
 	r.Put("/", updateConfig)
+	r.Patch("/", patchConfig)
 	return r
 }
 
@@ -37,10 +79,7 @@ func getConfig(w http.ResponseWriter, r *http.Request) {
 	)
 
 	for _, rule := range rulesCfg {
-		rules = append(rules, Rule{
-			Name:    rule.RuleType().String(),
-			Payload: rule.Payload(),
-		})
+		rules = append(rules, newRule(rule))
 	}
 
 	for _, proxy := range proxysCfg {
@@ -51,11 +90,183 @@ func getConfig(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, Configs{
 		Rules:  rules,
 		Proxys: proxys,
+		Ports:  P.GetPorts(),
 	})
 }
 
+func newRule(rule C.Rule) Rule {
+	r := Rule{
+		Name:    rule.RuleType().String(),
+		Payload: rule.Payload(),
+	}
+
+	if logic, ok := rule.(*R.Logic); ok {
+		for _, sub := range logic.Rules() {
+			r.Rules = append(r.Rules, newRule(sub))
+		}
+	}
+	return r
+}
+
 func updateConfig(w http.ResponseWriter, r *http.Request) {
-	err := tun.UpdateConfig()
+	req := &UpdateConfigs{}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+	if err == nil && len(bytes.TrimSpace(body)) > 0 {
+		err = json.Unmarshal(body, req)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, Error{
+			Error: "Format error",
+		})
+		return
+	}
+
+	// anyone reaching the controller could route all traffic through their own proxy
+	if !secured && (req.Payload != "" || req.Path != "") {
+		w.WriteHeader(http.StatusForbidden)
+		render.JSON(w, r, Error{
+			Error: "secret is required to change the config",
+		})
+		return
+	}
+
+	buf, format, err := readConfig(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, Error{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// the config is validated as a whole, nothing is applied on errors
+	if req.Payload == "" && req.Path == C.ConfigPath {
+		err = executor.ApplyFile(true)
+	} else {
+		err = executor.Apply(buf, format, req.Save && req.Path != C.ConfigPath)
+	}
+	if errs, ok := err.(config.Errors); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, ConfigError{
+			Error:  "Config error",
+			Errors: errs,
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, Error{
+			Error: err.Error(),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxConfigSize bounds the body of PUT /configs
+const maxConfigSize = 8 << 20
+
+// readConfig returns the config of a request and its format,
+// path has to be in the configuration directory, a relative one is joined to it
+func readConfig(req *UpdateConfigs) ([]byte, string, error) {
+	if req.Path != "" && req.Payload != "" {
+		return nil, "", errors.New("path and payload can't be used together")
+	}
+
+	var (
+		buf    []byte
+		format string
+	)
+	if req.Payload != "" {
+		buf, format = []byte(req.Payload), config.Format(C.ConfigPath)
+		if req.Format != "" {
+			format = req.Format
+		}
+		if format != "ini" && format != "yaml" {
+			return nil, "", fmt.Errorf("unsupported format %s", format)
+		}
+	} else {
+		// the config file itself may live outside of the config dir with -f
+		if req.Path == "" {
+			req.Path = C.ConfigPath
+		} else {
+			if !filepath.IsAbs(req.Path) {
+				req.Path = filepath.Join(C.Path, req.Path)
+			}
+			req.Path = filepath.Clean(req.Path)
+			if req.Path != C.ConfigPath && !inConfigDir(req.Path) {
+				return nil, "", fmt.Errorf("%s is not in %s", req.Path, C.Path)
+			}
+		}
+
+		var err error
+		if buf, err = ioutil.ReadFile(req.Path); err != nil {
+			return nil, "", err
+		}
+		format = config.Format(req.Path)
+	}
+
+	// the config file is parsed by its extension, it can't hold another format
+	if req.Save && format != config.Format(C.ConfigPath) {
+		return nil, "", fmt.Errorf("can't save a %s config to %s", format, C.ConfigPath)
+	}
+	return buf, format, nil
+}
+
+// inConfigDir tells whether path is in the configuration directory
+// once symlinks are resolved
+func inConfigDir(path string) bool {
+	dir, err := filepath.EvalSymlinks(C.Path)
+	if err != nil {
+		return false
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func patchConfig(w http.ResponseWriter, r *http.Request) {
+	req := &PatchConfigs{}
+	if err := render.DecodeJSON(r.Body, req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, Error{
+			Error: "Format error",
+		})
+		return
+	}
+
+	fields := []struct {
+		value *int
+		port  func(ports *P.Ports) *int
+	}{
+		{req.Port, func(ports *P.Ports) *int { return &ports.Port }},
+		{req.SocksPort, func(ports *P.Ports) *int { return &ports.SocksPort }},
+		{req.MixedPort, func(ports *P.Ports) *int { return &ports.MixedPort }},
+		{req.RedirPort, func(ports *P.Ports) *int { return &ports.RedirPort }},
+		{req.TProxyPort, func(ports *P.Ports) *int { return &ports.TProxyPort }},
+		{req.SSPort, func(ports *P.Ports) *int { return &ports.SSPort }},
+	}
+	for _, field := range fields {
+		if field.value != nil && (*field.value < 0 || *field.value > 65535) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, Error{
+				Error: "Port error",
+			})
+			return
+		}
+	}
+
+	// the running ports are read under the lock of config updates
+	err := executor.ReCreate(func(ports *P.Ports) {
+		for _, field := range fields {
+			if field.value != nil {
+				*field.port(ports) = *field.value
+			}
+		}
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.JSON(w, r, Error{
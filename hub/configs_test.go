@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	C "../constant"
+)
+
+func TestInConfigDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clash")
+	defer os.RemoveAll(dir)
+	home := filepath.Join(dir, "home")
+	os.Mkdir(home, 0755)
+	ioutil.WriteFile(filepath.Join(home, "other.yaml"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "outside.yaml"), nil, 0644)
+	os.Symlink(filepath.Join(dir, "outside.yaml"), filepath.Join(home, "link.yaml"))
+
+	defer C.SetHomeDir(C.Path)
+	C.SetHomeDir(home)
+
+	cases := map[string]bool{
+		filepath.Join(home, "other.yaml"):               true,
+		filepath.Join(home, "../outside.yaml"):          false,
+		filepath.Join(dir, "outside.yaml"):              false,
+		filepath.Join(home, "link.yaml"):                false,
+		filepath.Join(home, "missing.yaml"):             false,
+		filepath.Join(home, "..", "home", "other.yaml"): true,
+	}
+	for path, expected := range cases {
+		if inConfigDir(path) != expected {
+			t.Errorf("%s: expected %v", path, expected)
+		}
+	}
+}
+
+func TestReadConfig_ConfigFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clash")
+	defer os.RemoveAll(dir)
+	home := filepath.Join(dir, "home")
+	os.Mkdir(home, 0755)
+	file := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(file, []byte("port: 7890\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0644)
+
+	defer C.SetConfig(C.ConfigPath)
+	defer C.SetHomeDir(C.Path)
+	C.SetHomeDir(home)
+	C.SetConfig(file)
+
+	// -f may point outside of -d, reloading it is always allowed
+	for _, path := range []string{"", file} {
+		buf, _, err := readConfig(&UpdateConfigs{Path: path})
+		if err != nil {
+			t.Fatalf("%q: %s", path, err.Error())
+		}
+		if string(buf) != "port: 7890\n" {
+			t.Fatalf("%q: unexpected content %q", path, buf)
+		}
+	}
+
+	if _, _, err := readConfig(&UpdateConfigs{Path: filepath.Join(dir, "other.yaml")}); err == nil {
+		t.Fatal("other files outside of the config dir should be rejected")
+	}
+}
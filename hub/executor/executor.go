@@ -0,0 +1,101 @@
+// Package executor applies configs to the tunnel and the inbounds, one at a
+// time whether the config file changed, SIGHUP arrived or the external
+// controller asked for it
+package executor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"../../config"
+	C "../../constant"
+	P "../../proxy"
+	"../../tunnel"
+)
+
+var (
+	// lock is held from loading a config until it's fully applied
+	lock sync.Mutex
+
+	// applied is the digest of the config file content applied last,
+	// so the watcher ignores the writes of Apply
+	applied [sha256.Size]byte
+)
+
+// ApplyFile loads the config file and applies it, the running config is kept
+// when it's invalid. Unless force is set, content applied already is skipped
+func ApplyFile(force bool) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	buf, err := ioutil.ReadFile(C.ConfigPath)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf)
+	if !force && sum == applied {
+		return nil
+	}
+
+	cfg, err := config.Parse(buf, config.Format(C.ConfigPath))
+	if err != nil {
+		return err
+	}
+	if err := apply(cfg); err != nil {
+		return err
+	}
+	applied = sum
+	return nil
+}
+
+// Apply parses buf and applies it, then writes it to the config file when save is set.
+// Invalid configs return config.Errors
+func Apply(buf []byte, format string, save bool) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	cfg, err := config.Parse(buf, format)
+	if err != nil {
+		return err
+	}
+	if err := apply(cfg); err != nil {
+		return err
+	}
+
+	if save {
+		if err := config.Save(C.ConfigPath, buf); err != nil {
+			return fmt.Errorf("config applied but not saved: %s", err.Error())
+		}
+		applied = sha256.Sum256(buf)
+	}
+	return nil
+}
+
+// ReCreate rebinds the inbounds to the running ports changed by patch
+func ReCreate(patch func(ports *P.Ports)) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	ports := P.GetPorts()
+	patch(&ports)
+	return P.ReCreate(ports, P.BindAddress())
+}
+
+// apply binds the listeners of cfg before its rules and proxies take over,
+// the listeners roll themselves back and the rules are discarded on failure
+func apply(cfg *config.Config) error {
+	tun := tunnel.GetInstance()
+	update, err := tun.Prepare(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := P.UpdateConfig(cfg); err != nil {
+		update.Discard()
+		return err
+	}
+	tun.Commit(update)
+	return nil
+}
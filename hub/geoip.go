@@ -0,0 +1,27 @@
+package hub
+
+import (
+	"net/http"
+
+	R "../rules"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+func geoipRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Post("/update", updateGeoIP)
+	return r
+}
+
+func updateGeoIP(w http.ResponseWriter, r *http.Request) {
+	if err := R.UpdateGeoIP(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		render.JSON(w, r, Error{
+			Error: err.Error(),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
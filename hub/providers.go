@@ -0,0 +1,61 @@
+package hub
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+type RuleProvider struct {
+	Name      string    `json:"name"`
+	Behavior  string    `json:"behavior"`
+	Source    string    `json:"source"`
+	Count     int       `json:"count"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func providerRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/rules", getRuleProviders)
+	r.Put("/rules/{name}", updateRuleProvider)
+	return r
+}
+
+func getRuleProviders(w http.ResponseWriter, r *http.Request) {
+	providers := map[string]RuleProvider{}
+	for name, provider := range tun.RuleProviders() {
+		providers[name] = RuleProvider{
+			Name:      provider.Name(),
+			Behavior:  provider.Behavior(),
+			Source:    provider.Source(),
+			Count:     provider.Count(),
+			UpdatedAt: provider.UpdatedAt(),
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	render.JSON(w, r, providers)
+}
+
+func updateRuleProvider(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	provider, ok := tun.RuleProviders()[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, Error{
+			Error: "Rule provider not found",
+		})
+		return
+	}
+
+	if err := provider.Update(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		render.JSON(w, r, Error{
+			Error: err.Error(),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,8 +1,10 @@
 package hub
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"../tunnel"
@@ -14,6 +16,10 @@ import (
 
 var (
 	tun = tunnel.GetInstance()
+
+	// secured tells the controller requires a secret,
+	// configs are only taken from requests when it does
+	secured bool
 )
 
 type Traffic struct {
@@ -25,12 +31,18 @@ type Error struct {
 	Error string `json:"error"`
 }
 
-func NewHub(addr string) {
+// NewHub serves the external controller at addr,
+// every request has to carry "Authorization: Bearer <secret>" when secret is set
+func NewHub(addr string, secret string) {
+	secured = secret != ""
 	r := chi.NewRouter()
 
+	r.Use(authentication(secret))
 	r.Get("/traffic", traffic)
 	r.Get("/logs", getLogs)
 	r.Mount("/configs", configRouter())
+	r.Mount("/providers", providerRouter())
+	r.Mount("/geoip", geoipRouter())
 
 	err := http.ListenAndServe(addr, r)
 	if err != nil {
@@ -38,6 +50,31 @@ func NewHub(addr string) {
 	}
 }
 
+func authentication(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if secret == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := r.Header.Get("Authorization")
+			scheme, token := header, ""
+			if idx := strings.IndexByte(header, ' '); idx != -1 {
+				scheme, token = header[:idx], strings.TrimSpace(header[idx+1:])
+			}
+			if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				render.JSON(w, r, Error{
+					Error: "Unauthorized",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func traffic(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
@@ -1,49 +1,132 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"syscall"
 
+	"./config"
 	C "./constant"
 	"./hub"
-	"./proxy/http"
-	"./proxy/socks"
+	"./proxy"
+	"./proxy/tun"
 	"./tunnel"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Version is set by -ldflags "-X main.Version=..."
+var Version = "unknown"
+
+var (
+	homeDir            string
+	configFile         string
+	testConfig         bool
+	showVersion        bool
+	externalController string
+)
+
+func init() {
+	flag.StringVar(&homeDir, "d", "", "set configuration directory")
+	flag.StringVar(&configFile, "f", "", "specify configuration file")
+	flag.BoolVar(&testConfig, "t", false, "test configuration and exit")
+	flag.BoolVar(&showVersion, "v", false, "show current version of clash")
+	flag.StringVar(&externalController, "ext-ctl", "", "override external controller address")
+}
+
 func main() {
-	cfg, err := C.GetConfig()
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		os.Exit(runConvert(os.Args[2:]))
+	}
+	flag.Parse()
+
+	// registered first, the default action of SIGHUP would kill a slow first
+	// load, one received meanwhile reloads the config once the watch starts
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	if showVersion {
+		fmt.Printf("%s %s %s/%s %s\n", C.Name, Version, runtime.GOOS, runtime.GOARCH, runtime.Version())
+		return
+	}
+
+	dir, err := C.DefaultHomeDir()
+	if homeDir != "" {
+		dir, err = filepath.Abs(homeDir)
+	}
 	if err != nil {
-		log.Fatalf("Read config error: %s", err.Error())
+		log.Fatalf("Resolve home dir error: %s", err.Error())
 	}
+	C.SetHomeDir(dir)
 
-	port, socksPort := C.DefalutHTTPPort, C.DefalutSOCKSPort
-	section := cfg.Section("General")
-	if key, err := section.GetKey("port"); err == nil {
-		port = key.Value()
+	if configFile != "" {
+		file, err := filepath.Abs(configFile)
+		if err != nil {
+			log.Fatalf("Resolve config file error: %s", err.Error())
+		}
+		C.SetConfig(file)
 	}
 
-	if key, err := section.GetKey("socks-port"); err == nil {
-		socksPort = key.Value()
+	// testing a config doesn't create the home dir or an empty config
+	if testConfig {
+		os.Exit(runTest())
 	}
 
-	err = tunnel.GetInstance().UpdateConfig()
+	if err := C.Init(); err != nil {
+		log.Fatalf("Initial configuration directory error: %s", err.Error())
+	}
+
+	cfg, err := config.Load(C.ConfigPath)
+	if err != nil {
+		log.Fatalf("Read config error: %s", err.Error())
+	}
+	if externalController != "" {
+		cfg.General.ExternalController = externalController
+	}
+
+	err = tunnel.GetInstance().UpdateConfig(cfg)
 	if err != nil {
 		log.Fatalf("Parse config error: %s", err.Error())
 	}
 
-	go http.NewHttpProxy(port)
-	go socks.NewSocksProxy(socksPort)
+	// HTTP, SOCKS, mixed, redir, tproxy, ss inbounds and port forwards
+	if err := proxy.UpdateConfig(cfg); err != nil {
+		log.Fatalf("Start proxy error: %s", err.Error())
+	}
+
+	if cfg.General.TunDevice != "" {
+		go tun.NewTunProxy(cfg.General.TunDevice, cfg.General.TunDNSHijack)
+	}
 
 	// Hub
-	if key, err := section.GetKey("external-controller"); err == nil {
-		go hub.NewHub(key.Value())
+	if cfg.General.ExternalController != "" {
+		go hub.NewHub(cfg.General.ExternalController, cfg.General.Secret)
 	}
 
+	// reload on changes of the config file and SIGHUP
+	go watchConfig(hupCh)
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 }
+
+// runTest parses and validates the config without starting anything,
+// nothing is downloaded or written, the exit status is 0 when it's valid
+func runTest() int {
+	cfg, err := config.Load(C.ConfigPath)
+	if err == nil {
+		err = tunnel.GetInstance().CheckConfig(cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration file %s test failed:\n%s\n", C.ConfigPath, err.Error())
+		return 1
+	}
+
+	fmt.Printf("configuration file %s test is successful\n", C.ConfigPath)
+	return 0
+}
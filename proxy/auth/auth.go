@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"sync"
+)
+
+var (
+	authenticator = NewAuthenticator(nil)
+	lock          sync.RWMutex
+)
+
+type Authenticator struct {
+	users map[string]string
+}
+
+// Enabled reports whether any user is configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.users) != 0
+}
+
+func (a *Authenticator) Verify(user string, password string) bool {
+	expected, ok := a.users[user]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+func NewAuthenticator(users map[string]string) *Authenticator {
+	copied := map[string]string{}
+	for user, password := range users {
+		copied[user] = password
+	}
+	return &Authenticator{users: copied}
+}
+
+// Get returns the authenticator shared by HTTP and SOCKS inbounds
+func Get() *Authenticator {
+	lock.RLock()
+	defer lock.RUnlock()
+	return authenticator
+}
+
+// Set replaces the users, an empty map turns authentication off
+func Set(users map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+	authenticator = NewAuthenticator(users)
+}
@@ -6,7 +6,7 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"time"
+	"strings"
 
 	C "../../constant"
 )
@@ -33,32 +33,51 @@ func (h *HttpAdapter) Addr() *C.Addr {
 	return h.addr
 }
 
-// Connect 建立连接并转发HTTP请求到目标服务器
+// Connect 使用Tunnel建立的连接转发HTTP请求到目标服务器
 // proxy: 代理适配器，用于建立到目标服务器的连接
-// 这个方法实现了ServerAdapter接口
+// 连接只用于这一个请求，这个方法实现了ServerAdapter接口
 func (h *HttpAdapter) Connect(proxy C.ProxyAdapter) {
-	// 创建HTTP传输对象，用于转发请求到目标服务器
-	req := http.Transport{
-		// 自定义Dial函数，使用代理适配器建立连接
-		Dial: func(string, string) (net.Conn, error) {
-			return proxy.Conn(), nil
-		},
-		// 以下参数来自http.DefaultTransport的默认配置
-		MaxIdleConns:          100,              // 最大空闲连接数
-		IdleConnTimeout:       90 * time.Second, // 空闲连接超时时间
-		ExpectContinueTimeout: 1 * time.Second,  // Expect请求超时时间
+	// 创建一次性的HTTP传输对象，使用代理适配器的连接
+	transport := newTransport(func() (net.Conn, error) {
+		return adapterConn(proxy)
+	})
+	transport.DisableKeepAlives = true
+	h.roundTrip(transport)
+}
+
+// ConnectProxy 通过连接池转发HTTP请求到目标服务器
+// proxy: 规则匹配到的代理，经过它访问同一目标主机的请求复用上游连接
+// 这个方法实现了ProxyServerAdapter接口，Tunnel不再为每个请求新建连接
+func (h *HttpAdapter) ConnectProxy(proxy C.Proxy) {
+	h.roundTrip(pool.Get(proxy, h.addr))
+}
+
+// roundTrip 使用transport发送请求，并将响应写回客户端
+func (h *HttpAdapter) roundTrip(transport http.RoundTripper) {
+	// 构造发往上游的请求，去掉只属于客户端与代理之间这一跳的头部
+	req := h.r.WithContext(h.r.Context())
+	req.RequestURI = ""
+	req.Header = cloneHeader(h.r.Header)
+	removeHopByHopHeaders(req.Header)
+	if req.URL.Host == "" {
+		req.URL.Host = h.r.Host
+	}
+	if req.URL.Scheme == "" {
+		req.URL.Scheme = "http"
 	}
 
 	// 使用传输对象发送HTTP请求到目标服务器
-	resp, err := req.RoundTrip(h.r)
+	resp, err := transport.RoundTrip(req)
 	if err != nil {
-		// 如果请求失败，直接返回
+		// 如果请求失败，告知客户端上游不可用
+		h.w.WriteHeader(http.StatusBadGateway)
 		return
 	}
-	// 函数结束时关闭响应体
+	// 函数结束时关闭响应体，读完的响应体会让连接回到连接池
 	defer resp.Body.Close()
 
-	// 将目标服务器的响应头复制到客户端响应中
+	// 将目标服务器的响应头复制到客户端响应中，逐跳头部由服务器自己决定
+	removeHopByHopHeaders(resp.Header)
 	header := h.w.Header()
 	for k, vv := range resp.Header {
 		for _, v := range vv {
@@ -80,6 +99,43 @@ func (h *HttpAdapter) Connect(proxy C.ProxyAdapter) {
 	io.Copy(writer, resp.Body)
 }
 
+// hopByHopHeaders 是只在一跳连接上有效的头部，代理不能转发
+// 参见RFC 7230 6.1，Proxy-Connection是旧客户端使用的非标准头部
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders 删除逐跳头部，包括Connection头部中列出的头部
+func removeHopByHopHeaders(header http.Header) {
+	for _, v := range header["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// cloneHeader 复制请求头，避免修改客户端的原始请求
+func cloneHeader(header http.Header) http.Header {
+	h := make(http.Header, len(header))
+	for k, vv := range header {
+		h[k] = append([]string(nil), vv...)
+	}
+	return h
+}
+
 // ChunkWriter 是分块传输编码的写入器
 // 用于处理HTTP分块传输编码的响应数据
 type ChunkWriter struct {
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	C "../../constant"
+	"../auth"
+)
+
+type countingAdapter struct {
+	conn net.Conn
+}
+
+func (a *countingAdapter) ReadWriter() io.ReadWriter { return a.conn }
+func (a *countingAdapter) Conn() net.Conn            { return a.conn }
+func (a *countingAdapter) Close()                    { a.conn.Close() }
+
+// countingProxy dials the test server directly and counts the dials
+type countingProxy struct {
+	target string
+	dials  int32
+}
+
+func (p *countingProxy) Name() string { return "counting" }
+
+func (p *countingProxy) Generator(addr *C.Addr) (C.ProxyAdapter, error) {
+	atomic.AddInt32(&p.dials, 1)
+	c, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return nil, err
+	}
+	return &countingAdapter{conn: c}, nil
+}
+
+func TestConnectProxyReusesConnection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Proxy-Connection", "X-Hop", "Proxy-Authorization"} {
+			if r.Header.Get(name) != "" {
+				t.Errorf("hop-by-hop header %s was forwarded", name)
+			}
+		}
+		w.Header().Set("Keep-Alive", "timeout=5")
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	proxy := &countingProxy{target: server.Listener.Addr().String()}
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("Proxy-Connection", "keep-alive")
+		r.Header.Set("Connection", "X-Hop")
+		r.Header.Set("X-Hop", "1")
+		w := httptest.NewRecorder()
+
+		adapter, _ := NewHttp("example.com:80", w, r)
+		adapter.ConnectProxy(proxy)
+
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Keep-Alive") != "" {
+			t.Fatal("hop-by-hop response header was forwarded")
+		}
+	}
+
+	if dials := atomic.LoadInt32(&proxy.dials); dials != 1 {
+		t.Fatalf("expected 1 upstream connection, got %d", dials)
+	}
+}
+
+func TestAuthenticateScheme(t *testing.T) {
+	auth.Set(map[string]string{"alice": "password"})
+	defer auth.Set(nil)
+
+	// YWxpY2U6cGFzc3dvcmQ= is alice:password
+	cases := map[string]bool{
+		"Basic YWxpY2U6cGFzc3dvcmQ=":  true,
+		"basic YWxpY2U6cGFzc3dvcmQ=":  true,
+		"BASIC  YWxpY2U6cGFzc3dvcmQ=": true,
+		"Bearer YWxpY2U6cGFzc3dvcmQ=": false,
+		"YWxpY2U6cGFzc3dvcmQ=":        false,
+	}
+	for header, expected := range cases {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		r.Header.Set("Proxy-Authorization", header)
+		if user, ok := authenticate(r); ok != expected || (ok && user != "alice") {
+			t.Errorf("%q should be accepted: %v", header, expected)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	C "../../constant"
+)
+
+const (
+	// idleTimeout 上游空闲连接的保持时间，也是连接池本身无人使用后被回收的时间
+	idleTimeout = 90 * time.Second
+)
+
+// 全局连接池，所有HTTP入口共用
+var pool = newTransportPool()
+
+// transportKey 标识一个连接池
+// 经过同一个代理访问同一个目标主机的请求复用同一组上游连接
+type transportKey struct {
+	proxy C.Proxy // 规则匹配到的代理，配置重载后是新的实例，旧连接池自然过期
+	host  string  // 目标地址，格式为"host:port"
+}
+
+// pooledTransport 是带最近使用时间的http.Transport
+type pooledTransport struct {
+	*http.Transport
+	lastUsed time.Time
+}
+
+// transportPool 管理所有(代理, 目标主机)对应的连接池
+type transportPool struct {
+	transports map[transportKey]*pooledTransport
+	lock       sync.Mutex
+}
+
+// Get 返回经过proxy访问addr的http.Transport，不存在时创建
+// 新连接通过proxy.Generator建立，因此流量统计和代理逻辑与其他入口一致
+func (p *transportPool) Get(proxy C.Proxy, addr *C.Addr) *http.Transport {
+	key := transportKey{proxy: proxy, host: net.JoinHostPort(addr.String(), addr.Port)}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	t, ok := p.transports[key]
+	if !ok {
+		t = &pooledTransport{Transport: newTransport(func() (net.Conn, error) {
+			adapter, err := proxy.Generator(addr)
+			if err != nil {
+				return nil, err
+			}
+			return adapterConn(adapter)
+		})}
+		p.transports[key] = t
+	}
+	t.lastUsed = time.Now()
+	return t.Transport
+}
+
+// cleanup 定期回收长时间未使用的连接池
+func (p *transportPool) cleanup() {
+	ticker := time.NewTicker(idleTimeout)
+	for range ticker.C {
+		p.lock.Lock()
+		for key, t := range p.transports {
+			if time.Since(t.lastUsed) > idleTimeout {
+				t.CloseIdleConnections()
+				delete(p.transports, key)
+			}
+		}
+		p.lock.Unlock()
+	}
+}
+
+// newTransportPool 创建连接池并启动回收协程
+func newTransportPool() *transportPool {
+	p := &transportPool{transports: make(map[transportKey]*pooledTransport)}
+	go p.cleanup()
+	return p
+}
+
+// newTransport 创建一个通过dial建立上游连接的http.Transport
+func newTransport(dial func() (net.Conn, error)) *http.Transport {
+	return &http.Transport{
+		// 目标地址已经由规则匹配确定，忽略Transport传入的地址
+		Dial: func(string, string) (net.Conn, error) {
+			return dial()
+		},
+		// 保留客户端原始的Accept-Encoding，响应体原样转发
+		DisableCompression: true,
+		// 以下参数来自http.DefaultTransport的默认配置
+		MaxIdleConns:          100,
+		IdleConnTimeout:       idleTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// adapterConn 取出代理适配器的底层连接
+// REJECT等适配器没有连接，此时返回错误而不是nil连接
+func adapterConn(adapter C.ProxyAdapter) (net.Conn, error) {
+	conn := adapter.Conn()
+	if conn == nil {
+		adapter.Close()
+		return nil, errors.New("connection rejected")
+	}
+	return conn, nil
+}
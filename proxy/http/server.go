@@ -3,13 +3,16 @@
 package http
 
 import (
-	"fmt"
+	"context"
+	"encoding/base64"
 	"net"
 	"net/http"
 	"strings"
 
 	C "../../constant"
 	"../../tunnel"
+	"../auth"
+	"../sniffer"
 
 	"github.com/riobard/go-shadowsocks2/socks"
 	log "github.com/sirupsen/logrus"
@@ -21,37 +24,104 @@ var (
 	tun = tunnel.GetInstance()
 )
 
+// HttpListener 是一个正在运行的HTTP代理入口
+type HttpListener struct {
+	net.Listener
+	// address 监听地址
+	address string
+	// server 处理该入口请求的HTTP服务器
+	server *http.Server
+}
+
 // NewHttpProxy 创建并启动HTTP代理服务器
-// port: 监听端口号
-func NewHttpProxy(port string) {
-	// 创建HTTP服务器实例
-	server := &http.Server{
-		// 设置服务器监听地址
-		Addr: fmt.Sprintf(":%s", port),
-		// 设置请求处理器
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// 根据请求方法进行不同处理
-			if r.Method == http.MethodConnect {
-				// HTTPS CONNECT请求处理
-				handleTunneling(w, r)
-			} else {
-				// 普通HTTP请求处理
-				handleHTTP(w, r)
-			}
-		}),
+// addr: 监听地址，格式为"host:port"
+// 返回: 运行中的入口，监听失败时返回错误，监听成功后在后台提供服务
+func NewHttpProxy(addr string) (*HttpListener, error) {
+	// 先完成监听，确保端口占用等错误能返回给调用方
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
 	}
 
+	// 创建HTTP服务器实例，设置请求处理器
+	server := &http.Server{Handler: Handler()}
+
 	// 记录日志信息
-	log.Infof("HTTP proxy :%s", port)
+	log.Infof("HTTP proxy listening at: %s", addr)
+
+	// 在后台启动HTTP服务
+	go server.Serve(l)
+	return &HttpListener{Listener: l, address: addr, server: server}, nil
+}
 
-	// 启动HTTP服务器并开始监听
-	server.ListenAndServe()
+// Close 停止HTTP代理入口
+// 监听端口立即释放，正在处理的请求在后台继续完成，空闲的长连接会被关闭
+func (l *HttpListener) Close() {
+	l.Listener.Close()
+	go l.server.Shutdown(context.Background())
+}
+
+// Address 返回监听地址
+func (l *HttpListener) Address() string {
+	return l.address
+}
+
+// Handler 返回HTTP代理的请求处理器
+// 混合端口等其他入口也通过它处理HTTP代理请求
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 开启认证时校验Proxy-Authorization，失败则返回407要求客户端认证
+		user, ok := authenticate(r)
+		if !ok {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="clash"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		// 认证信息只用于代理本身，不转发给目标服务器
+		r.Header.Del("Proxy-Authorization")
+
+		// 根据请求方法进行不同处理
+		if r.Method == http.MethodConnect {
+			// HTTPS CONNECT请求处理
+			handleTunneling(w, r, user)
+		} else {
+			// 普通HTTP请求处理
+			handleHTTP(w, r, user)
+		}
+	})
+}
+
+// authenticate 校验请求中的Basic认证信息
+// 返回: 认证通过的用户名（未开启认证时为空）和是否允许访问
+func authenticate(r *http.Request) (string, bool) {
+	authenticator := auth.Get()
+	if !authenticator.Enabled() {
+		return "", true
+	}
+
+	// Proxy-Authorization: Basic base64(user:password)，认证方案不区分大小写
+	scheme := strings.SplitN(r.Header.Get("Proxy-Authorization"), " ", 2)
+	if len(scheme) != 2 || !strings.EqualFold(scheme[0], "Basic") {
+		return "", false
+	}
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(scheme[1]))
+	if err != nil {
+		return "", false
+	}
+
+	pair := strings.SplitN(string(buf), ":", 2)
+	if len(pair) != 2 || !authenticator.Verify(pair[0], pair[1]) {
+		return "", false
+	}
+	return pair[0], true
 }
 
 // handleHTTP 处理普通的HTTP请求
 // w: HTTP响应写入器
 // r: HTTP请求
-func handleHTTP(w http.ResponseWriter, r *http.Request) {
+// user: 认证通过的用户名
+func handleHTTP(w http.ResponseWriter, r *http.Request, user string) {
 	// 获取目标地址
 	addr := r.Host
 
@@ -63,6 +133,7 @@ func handleHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// 创建HTTP适配器实例
 	req, done := NewHttp(addr, w, r)
+	req.addr.User = user
 
 	// 将请求添加到Tunnel处理队列中
 	tun.Add(req)
@@ -74,7 +145,8 @@ func handleHTTP(w http.ResponseWriter, r *http.Request) {
 // handleTunneling 处理HTTPS的CONNECT请求，建立隧道连接
 // w: HTTP响应写入器
 // r: HTTP请求
-func handleTunneling(w http.ResponseWriter, r *http.Request) {
+// user: 认证通过的用户名
+func handleTunneling(w http.ResponseWriter, r *http.Request, user string) {
 	// 检查响应写入器是否实现了Hijacker接口
 	// Hijacker接口允许接管底层连接
 	hijacker, ok := w.(http.Hijacker)
@@ -95,7 +167,12 @@ func handleTunneling(w http.ResponseWriter, r *http.Request) {
 	conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
 
 	// 创建HTTPS适配器并添加到Tunnel处理队列
-	tun.Add(NewHttps(r.Host, conn))
+	req := NewHttps(r.Host, conn)
+	req.addr.User = user
+
+	// 目标只有IP时，尝试从TLS握手或HTTP请求中识别域名，使域名规则生效
+	req.conn = sniffer.Get().Sniff(conn, req.addr)
+	tun.Add(req)
 }
 
 // parseHttpAddr 解析HTTP目标地址并创建Addr结构体
@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"../config"
+	"./auth"
+	"./http"
+	"./mixed"
+	"./redir"
+	"./shadowsocks"
+	"./sniffer"
+	"./socks"
+	"./tproxy"
+	"./tunnel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// only listen on loopback unless allow-lan is set
+	bindAddress = []string{"127.0.0.1"}
+	ports       = Ports{}
+	listeners   = map[string][]listener{}
+	lock        sync.Mutex
+
+	// cipher and password of the Shadowsocks inbound
+	ssCipher, ssPassword string
+
+	// port forwards of [Tunnel] by name
+	tunnels = map[string]*forward{}
+)
+
+// Ports of the inbounds, 0 means disabled
+type Ports struct {
+	Port       int `json:"port"`
+	SocksPort  int `json:"socks-port"`
+	MixedPort  int `json:"mixed-port"`
+	RedirPort  int `json:"redir-port"`
+	TProxyPort int `json:"tproxy-port"`
+	SSPort     int `json:"ss-port"`
+}
+
+type listener interface {
+	Close()
+	Address() string
+}
+
+// forward is a [Tunnel] entry: network, listen address, target and an optional proxy
+type forward struct {
+	network  string
+	address  string
+	target   string
+	proxy    string
+	listener listener
+}
+
+func (f *forward) equal(o *forward) bool {
+	return f.network == o.network && f.address == o.address && f.target == o.target && f.proxy == o.proxy
+}
+
+type inbound struct {
+	name   string
+	port   func(p *Ports) *int
+	create func(addr string) (listener, error)
+}
+
+var inbounds = []inbound{
+	{
+		name:   "HTTP proxy",
+		port:   func(p *Ports) *int { return &p.Port },
+		create: func(addr string) (listener, error) { return http.NewHttpProxy(addr) },
+	},
+	{
+		name:   "SOCKS proxy",
+		port:   func(p *Ports) *int { return &p.SocksPort },
+		create: func(addr string) (listener, error) { return socks.NewSocksProxy(addr) },
+	},
+	{
+		name:   "Mixed proxy",
+		port:   func(p *Ports) *int { return &p.MixedPort },
+		create: func(addr string) (listener, error) { return mixed.NewMixedProxy(addr) },
+	},
+	{
+		name:   "Redir proxy",
+		port:   func(p *Ports) *int { return &p.RedirPort },
+		create: func(addr string) (listener, error) { return redir.NewRedirProxy(addr) },
+	},
+	{
+		name:   "TProxy",
+		port:   func(p *Ports) *int { return &p.TProxyPort },
+		create: func(addr string) (listener, error) { return tproxy.NewTProxy(addr) },
+	},
+	{
+		name: "ShadowSocks proxy",
+		port: func(p *Ports) *int { return &p.SSPort },
+		create: func(addr string) (listener, error) {
+			return shadowsocks.NewShadowSocksProxy(addr, ssCipher, ssPassword)
+		},
+	},
+}
+
+// GetPorts returns the ports of the running inbounds
+func GetPorts() Ports {
+	lock.Lock()
+	defer lock.Unlock()
+	return ports
+}
+
+// BindAddress returns the addresses the inbounds listen on, "" means all interfaces
+func BindAddress() []string {
+	lock.Lock()
+	defer lock.Unlock()
+	return bindAddress
+}
+
+// UpdateConfig rebinds the inbounds and port forwards that changed,
+// users and the sniffer of the inbounds are replaced as well.
+// Nothing changes when any of them fails
+func UpdateConfig(cfg *config.Config) error {
+	sniff, err := sniffer.NewSniffer(cfg.Sniffer.TLSPorts, cfg.Sniffer.HTTPPorts, cfg.Sniffer.SkipDomain)
+	if err != nil {
+		return err
+	}
+
+	general := cfg.General
+	p := Ports{
+		Port:       general.Port,
+		SocksPort:  general.SocksPort,
+		MixedPort:  general.MixedPort,
+		RedirPort:  general.RedirPort,
+		TProxyPort: general.TProxyPort,
+		SSPort:     general.SSPort,
+	}
+
+	forwards := map[string]*forward{}
+	for _, t := range cfg.Tunnels {
+		forwards[t.Name] = &forward{
+			network: t.Network,
+			address: t.Address,
+			target:  t.Target,
+			proxy:   t.Proxy,
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	oldPorts, oldAddrs, oldCipher, oldPassword := ports, bindAddress, ssCipher, ssPassword
+	if err := recreate(p, general.BindAddress, general.SSCipher, general.SSPassword); err != nil {
+		return err
+	}
+	if err := updateTunnels(forwards); err != nil {
+		// the inbounds go back as well, the old config keeps running as a whole
+		if err := recreate(oldPorts, oldAddrs, oldCipher, oldPassword); err != nil {
+			log.Errorf("Inbound restore error: %s", err.Error())
+		}
+		return err
+	}
+
+	// users and the sniffer change only along with the listeners
+	auth.Set(cfg.Users)
+	sniffer.Set(sniff)
+	return nil
+}
+
+// updateTunnels replaces the port forwards that changed,
+// when one of them fails to listen the old forwards are restored
+func updateTunnels(forwards map[string]*forward) error {
+	removed := map[string]*forward{}
+	for name, old := range tunnels {
+		if f, ok := forwards[name]; ok && f.equal(old) {
+			continue
+		}
+		closeAll([]listener{old.listener})
+		delete(tunnels, name)
+		removed[name] = old
+	}
+
+	created := []string{}
+	for name, f := range forwards {
+		if _, ok := tunnels[name]; ok {
+			continue
+		}
+
+		l, err := tunnel.NewTunnel(f.network, f.address, f.target, f.proxy)
+		if err != nil {
+			for _, name := range created {
+				closeAll([]listener{tunnels[name].listener})
+				delete(tunnels, name)
+			}
+			restoreTunnels(removed)
+			return fmt.Errorf("Tunnel %s listen at %s error: %s", name, f.address, err.Error())
+		}
+		f.listener = l
+		tunnels[name] = f
+		created = append(created, name)
+	}
+	return nil
+}
+
+func restoreTunnels(forwards map[string]*forward) {
+	for name, f := range forwards {
+		l, err := tunnel.NewTunnel(f.network, f.address, f.target, f.proxy)
+		if err != nil {
+			log.Errorf("Tunnel %s restore error: %s", name, err.Error())
+			continue
+		}
+		f.listener = l
+		tunnels[name] = f
+	}
+}
+
+// ReCreate rebinds every inbound whose port or bind addresses changed,
+// when any of them fails to bind all of them keep their old listeners
+func ReCreate(p Ports, addrs []string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	return recreate(p, addrs, ssCipher, ssPassword)
+}
+
+// recreate also rebinds the Shadowsocks inbound when its cipher or password changed,
+// they are kept only when every inbound is bound
+func recreate(p Ports, addrs []string, cipher, password string) error {
+	oldCipher, oldPassword := ssCipher, ssPassword
+	force := map[string]bool{"ShadowSocks proxy": cipher != oldCipher || password != oldPassword}
+
+	// the create of the Shadowsocks inbound reads them
+	ssCipher, ssPassword = cipher, password
+
+	rebind := !equalAddress(bindAddress, addrs)
+	changed := []inbound{}
+	var failed error
+	for _, in := range inbounds {
+		oldPort, newPort := *in.port(&ports), *in.port(&p)
+		if oldPort == newPort && !rebind && !force[in.name] {
+			continue
+		}
+
+		// the new addresses may overlap with the old ones, so the old
+		// listeners are closed first and restored when binding fails
+		closeAll(listeners[in.name])
+		listeners[in.name] = nil
+		changed = append(changed, in)
+
+		created, err := createAll(in, newPort, addrs)
+		if err != nil {
+			failed = err
+			break
+		}
+		listeners[in.name] = created
+	}
+
+	if failed == nil {
+		ports = p
+		bindAddress = addrs
+		return nil
+	}
+
+	// restore the old listeners of every inbound touched, the ports and
+	// addresses keep describing what is actually listening
+	ssCipher, ssPassword = oldCipher, oldPassword
+	actual := ports
+	for _, in := range changed {
+		closeAll(listeners[in.name])
+		created, err := createAll(in, *in.port(&ports), bindAddress)
+		if err != nil {
+			log.Errorf("%s restore error: %s", in.name, err.Error())
+			*in.port(&actual) = 0
+		}
+		listeners[in.name] = created
+	}
+	ports = actual
+	return failed
+}
+
+func createAll(in inbound, port int, addrs []string) ([]listener, error) {
+	if port == 0 {
+		return nil, nil
+	}
+
+	created := []listener{}
+	for _, host := range addrs {
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		l, err := in.create(addr)
+		if err != nil {
+			closeAll(created)
+			return nil, fmt.Errorf("%s listen at %s error: %s", in.name, addr, err.Error())
+		}
+		created = append(created, l)
+	}
+	return created, nil
+}
+
+func closeAll(ls []listener) {
+	for _, l := range ls {
+		l.Close()
+		log.Infof("Closed listener at: %s", l.Address())
+	}
+}
+
+func equalAddress(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"../config"
+)
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func canDial(port int) bool {
+	c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+func TestReCreate(t *testing.T) {
+	loopback := []string{"127.0.0.1"}
+	defer ReCreate(Ports{}, loopback)
+
+	first := freePort(t)
+	if err := ReCreate(Ports{SocksPort: first}, loopback); err != nil {
+		t.Fatal(err)
+	}
+	if !canDial(first) {
+		t.Fatalf("port %d is not listening", first)
+	}
+
+	second := freePort(t)
+	if err := ReCreate(Ports{SocksPort: second}, loopback); err != nil {
+		t.Fatal(err)
+	}
+	if canDial(first) || !canDial(second) {
+		t.Fatalf("socks should move from %d to %d", first, second)
+	}
+
+	// a port in use fails and keeps the old listener
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+	if err := ReCreate(Ports{SocksPort: busyPort}, loopback); err == nil {
+		t.Fatal("expected an error for a port in use")
+	}
+	if GetPorts().SocksPort != second || !canDial(second) {
+		t.Fatalf("socks should stay on %d, got %d", second, GetPorts().SocksPort)
+	}
+
+	if err := ReCreate(Ports{}, loopback); err != nil {
+		t.Fatal(err)
+	}
+	if canDial(second) {
+		t.Fatalf("port %d should be closed", second)
+	}
+}
+
+func TestReCreate_Redir(t *testing.T) {
+	loopback := []string{"127.0.0.1"}
+	defer ReCreate(Ports{}, loopback)
+
+	// redir follows the bind addresses like the other inbounds
+	port := freePort(t)
+	if err := ReCreate(Ports{RedirPort: port}, loopback); err != nil {
+		t.Fatal(err)
+	}
+	ls := listeners["Redir proxy"]
+	if len(ls) != 1 || ls[0].Address() != net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) {
+		t.Fatalf("redir should listen on loopback, got %v", ls)
+	}
+
+	if err := ReCreate(Ports{RedirPort: port}, []string{""}); err != nil {
+		t.Fatal(err)
+	}
+	ls = listeners["Redir proxy"]
+	if len(ls) != 1 || ls[0].Address() != net.JoinHostPort("", strconv.Itoa(port)) {
+		t.Fatalf("redir should listen on all interfaces, got %v", ls)
+	}
+}
+
+func TestReCreate_Rollback(t *testing.T) {
+	loopback := []string{"127.0.0.1"}
+	defer ReCreate(Ports{}, loopback)
+
+	http, socks := freePort(t), freePort(t)
+	if err := ReCreate(Ports{Port: http, SocksPort: socks}, loopback); err != nil {
+		t.Fatal(err)
+	}
+
+	// HTTP moves before SOCKS fails, both keep their old ports
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	moved := freePort(t)
+	if err := ReCreate(Ports{Port: moved, SocksPort: busy.Addr().(*net.TCPAddr).Port}, []string{"127.0.0.1", "127.0.0.2"}); err == nil {
+		t.Fatal("expected an error for a port in use")
+	}
+	if ports := GetPorts(); ports.Port != http || ports.SocksPort != socks {
+		t.Fatalf("ports should be kept, got %+v", ports)
+	}
+	if !canDial(http) || !canDial(socks) || canDial(moved) {
+		t.Fatal("the old listeners should be restored")
+	}
+	if addrs := BindAddress(); len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Fatalf("bind addresses should be kept, got %v", addrs)
+	}
+}
+
+func TestReCreate_ShadowSocks(t *testing.T) {
+	loopback := []string{"127.0.0.1"}
+	defer func() {
+		lock.Lock()
+		recreate(Ports{}, loopback, "", "")
+		lock.Unlock()
+	}()
+
+	port := freePort(t)
+	lock.Lock()
+	err := recreate(Ports{SSPort: port}, loopback, "AEAD_CHACHA20_POLY1305", "password")
+	lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bad cipher keeps the running inbound and its credentials
+	lock.Lock()
+	err = recreate(Ports{SSPort: port}, loopback, "bad-cipher", "password")
+	cipher := ssCipher
+	lock.Unlock()
+	if err == nil {
+		t.Fatal("expected an error for a bad cipher")
+	}
+	if cipher != "AEAD_CHACHA20_POLY1305" || GetPorts().SSPort != port || !canDial(port) {
+		t.Fatal("the old inbound should be restored")
+	}
+
+	// so the same bad cipher fails again instead of comparing equal
+	lock.Lock()
+	err = recreate(Ports{SSPort: port}, loopback, "bad-cipher", "password")
+	lock.Unlock()
+	if err == nil {
+		t.Fatal("the bad cipher should be retried")
+	}
+}
+
+func TestUpdateConfig_Rollback(t *testing.T) {
+	loopback := []string{"127.0.0.1"}
+	newConfig := func(socks int, forward string) *config.Config {
+		return &config.Config{
+			General: config.General{SocksPort: socks, BindAddress: loopback},
+			Tunnels: []config.Tunnel{{Name: "dns", Network: "tcp", Address: forward, Target: "1.1.1.1:53"}},
+		}
+	}
+	defer UpdateConfig(&config.Config{General: config.General{BindAddress: loopback}})
+
+	socks, forward := freePort(t), freePort(t)
+	if err := UpdateConfig(newConfig(socks, net.JoinHostPort("127.0.0.1", strconv.Itoa(forward)))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the inbounds are bound before the forward fails, both go back
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	moved := freePort(t)
+	if err := UpdateConfig(newConfig(moved, busy.Addr().String())); err == nil {
+		t.Fatal("expected an error for a port in use")
+	}
+	if GetPorts().SocksPort != socks || !canDial(socks) || canDial(moved) {
+		t.Fatal("the old inbounds should be restored")
+	}
+	lock.Lock()
+	f := tunnels["dns"]
+	lock.Unlock()
+	if f == nil || f.listener.Address() != net.JoinHostPort("127.0.0.1", strconv.Itoa(forward)) {
+		t.Fatal("the old forward should be restored")
+	}
+}
@@ -0,0 +1,142 @@
+package mixed
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	H "../http"
+	"../socks"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type MixedListener struct {
+	net.Listener
+	address      string
+	httpListener *chanListener
+	server       *http.Server
+	done         chan struct{}
+}
+
+// NewMixedProxy serves SOCKS and HTTP proxy on the same port,
+// the first byte of a connection is the SOCKS version or an HTTP method
+func NewMixedProxy(addr string) (*MixedListener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ml := &MixedListener{
+		Listener:     l,
+		address:      addr,
+		httpListener: newChanListener(l.Addr()),
+		server:       &http.Server{Handler: H.Handler()},
+		done:         make(chan struct{}),
+	}
+	go ml.server.Serve(ml.httpListener)
+
+	log.Infof("Mixed proxy listening at: %s", addr)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				select {
+				case <-ml.done:
+					return
+				default:
+					continue
+				}
+			}
+			go handleConn(c, ml.httpListener)
+		}
+	}()
+	return ml, nil
+}
+
+// Close stops accepting, requests in flight are left to finish
+func (l *MixedListener) Close() {
+	close(l.done)
+	l.Listener.Close()
+	l.httpListener.Close()
+	go l.server.Shutdown(context.Background())
+}
+
+func (l *MixedListener) Address() string {
+	return l.address
+}
+
+func handleConn(conn net.Conn, httpListener *chanListener) {
+	conn.(*net.TCPConn).SetKeepAlive(true)
+
+	bufConn := &peekedConn{Conn: conn, r: bufio.NewReader(conn)}
+	head, err := bufConn.r.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	switch head[0] {
+	case 0x04, 0x05:
+		socks.HandleSocks(bufConn)
+	default:
+		httpListener.Push(bufConn)
+	}
+}
+
+// peekedConn keeps the peeked bytes for the real handler
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (pc *peekedConn) Read(b []byte) (int, error) {
+	return pc.r.Read(b)
+}
+
+// chanListener hands connections detected as HTTP to http.Serve
+type chanListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (cl *chanListener) Push(conn net.Conn) {
+	select {
+	case cl.conns <- conn:
+	case <-cl.closed:
+		conn.Close()
+	}
+}
+
+func (cl *chanListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-cl.conns:
+		return conn, nil
+	case <-cl.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (cl *chanListener) Close() error {
+	cl.once.Do(func() {
+		close(cl.closed)
+	})
+	return nil
+}
+
+func (cl *chanListener) Addr() net.Addr {
+	return cl.addr
+}
+
+func newChanListener(addr net.Addr) *chanListener {
+	return &chanListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
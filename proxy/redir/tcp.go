@@ -0,0 +1,102 @@
+package redir
+
+import (
+	"io"
+	"net"
+	"strconv"
+
+	C "../../constant"
+	"../../tunnel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tun = tunnel.GetInstance()
+)
+
+type RedirListener struct {
+	net.Listener
+	address string
+	done    chan struct{}
+}
+
+func NewRedirProxy(addr string) (*RedirListener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := &RedirListener{l, addr, make(chan struct{})}
+	log.Infof("Redir proxy listening at: %s", addr)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				select {
+				case <-rl.done:
+					return
+				default:
+					continue
+				}
+			}
+			go handleRedir(c)
+		}
+	}()
+	return rl, nil
+}
+
+// Close stops accepting, connections already accepted are left to finish
+func (l *RedirListener) Close() {
+	close(l.done)
+	l.Listener.Close()
+}
+
+func (l *RedirListener) Address() string {
+	return l.address
+}
+
+func handleRedir(conn net.Conn) {
+	target, err := parserPacket(conn)
+	if err != nil {
+		log.Warnf("Redir original destination error: %s", err.Error())
+		conn.Close()
+		return
+	}
+
+	// a connection made to the port directly isn't redirected, its
+	// destination is the listener and relaying it would loop back here
+	local := conn.LocalAddr().(*net.TCPAddr)
+	if target.IP.Equal(local.IP) && target.Port == strconv.Itoa(local.Port) {
+		log.Warnf("Redir rejected %s, the destination is the listener itself", conn.RemoteAddr().String())
+		conn.Close()
+		return
+	}
+	conn.(*net.TCPConn).SetKeepAlive(true)
+	tun.Add(NewRedir(target, conn))
+}
+
+type RedirAdapter struct {
+	conn net.Conn
+	addr *C.Addr
+}
+
+func (r *RedirAdapter) Close() {
+	r.conn.Close()
+}
+
+func (r *RedirAdapter) Addr() *C.Addr {
+	return r.addr
+}
+
+func (r *RedirAdapter) Connect(proxy C.ProxyAdapter) {
+	go io.Copy(r.conn, proxy.ReadWriter())
+	io.Copy(proxy.ReadWriter(), r.conn)
+}
+
+func NewRedir(addr *C.Addr, conn net.Conn) *RedirAdapter {
+	return &RedirAdapter{
+		conn: conn,
+		addr: addr,
+	}
+}
@@ -0,0 +1,92 @@
+//go:build linux && !386
+// +build linux,!386
+
+package redir
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	C "../../constant"
+)
+
+const (
+	SO_ORIGINAL_DST      = 80 // from linux/include/uapi/linux/netfilter_ipv4.h
+	IP6T_SO_ORIGINAL_DST = 80 // from linux/include/uapi/linux/netfilter_ipv6/ip6_tables.h
+)
+
+func parserPacket(conn net.Conn) (*C.Addr, error) {
+	c, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("only work with TCP connection")
+	}
+
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	ipv6 := conn.LocalAddr().(*net.TCPAddr).IP.To4() == nil
+
+	var addr *C.Addr
+	var innerErr error
+	err = rc.Control(func(fd uintptr) {
+		if ipv6 {
+			addr, innerErr = getorigdst6(fd)
+		} else {
+			addr, innerErr = getorigdst(fd)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addr, innerErr
+}
+
+// Call getorigdst() from linux/net/ipv4/netfilter/nf_conntrack_l3proto_ipv4.c
+func getorigdst(fd uintptr) (*C.Addr, error) {
+	raw := syscall.RawSockaddrInet4{}
+	siz := unsafe.Sizeof(raw)
+	if err := getsockopt(fd, syscall.IPPROTO_IP, SO_ORIGINAL_DST, unsafe.Pointer(&raw), &siz); err != nil {
+		return nil, err
+	}
+
+	ip := net.IPv4(raw.Addr[0], raw.Addr[1], raw.Addr[2], raw.Addr[3])
+	return newAddr(C.AtypIPv4, ip, raw.Port), nil
+}
+
+// Call ipv6_getorigdst() from linux/net/ipv6/netfilter/nf_conntrack_l3proto_ipv6.c
+func getorigdst6(fd uintptr) (*C.Addr, error) {
+	raw := syscall.RawSockaddrInet6{}
+	siz := unsafe.Sizeof(raw)
+	if err := getsockopt(fd, syscall.IPPROTO_IPV6, IP6T_SO_ORIGINAL_DST, unsafe.Pointer(&raw), &siz); err != nil {
+		return nil, err
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, raw.Addr[:])
+	return newAddr(C.AtypIPv6, ip, raw.Port), nil
+}
+
+// newAddr converts the port from network byte order stored in a RawSockaddr
+func newAddr(addrType int, ip net.IP, rawPort uint16) *C.Addr {
+	p := (*[2]byte)(unsafe.Pointer(&rawPort))
+	port := int(p[0])<<8 | int(p[1])
+	return &C.Addr{
+		NetWork:  C.TCP,
+		AddrType: addrType,
+		IP:       &ip,
+		Port:     strconv.Itoa(port),
+	}
+}
+
+func getsockopt(fd uintptr, level, name int, optval unsafe.Pointer, optlen *uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, uintptr(level), uintptr(name), uintptr(optval), uintptr(unsafe.Pointer(optlen)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+//go:build linux && !386
+// +build linux,!386
+
+package redir
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+// TestGetOrigDst needs iptables inside an isolated network namespace:
+// CLASH_NETNS=1 unshare -rn go test ./proxy/redir/
+func TestGetOrigDst(t *testing.T) {
+	if os.Getenv("CLASH_NETNS") == "" {
+		t.Skip("CLASH_NETNS is not set")
+	}
+
+	run := func(name string, args ...string) {
+		if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %v: %s %s", name, args, err.Error(), out)
+		}
+	}
+
+	run("ip", "link", "set", "lo", "up")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	run("iptables", "-t", "nat", "-A", "OUTPUT", "-p", "tcp", "-d", "127.0.0.2", "--dport", "8080", "-j", "REDIRECT", "--to-ports", port)
+
+	go func() {
+		if c, err := net.Dial("tcp", "127.0.0.2:8080"); err == nil {
+			defer c.Close()
+			c.Read(make([]byte, 1))
+		}
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	addr, err := parserPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !addr.IP.Equal(net.ParseIP("127.0.0.2")) || addr.Port != "8080" {
+		t.Errorf("original destination error: %s:%s", addr.IP.String(), addr.Port)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux || 386
+// +build !linux 386
+
+package redir
+
+import (
+	"errors"
+	"net"
+
+	C "../../constant"
+)
+
+func parserPacket(conn net.Conn) (*C.Addr, error) {
+	return nil, errors.New("redir proxy is only supported on linux")
+}
@@ -0,0 +1,111 @@
+package shadowsocks
+
+import (
+	"io"
+	"net"
+
+	C "../../constant"
+	"../../tunnel"
+	S "../socks"
+
+	"github.com/riobard/go-shadowsocks2/core"
+	"github.com/riobard/go-shadowsocks2/socks"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tun = tunnel.GetInstance()
+)
+
+type ShadowSocksListener struct {
+	net.Listener
+	udp     *UDPListener
+	address string
+	done    chan struct{}
+}
+
+// NewShadowSocksProxy serves a Shadowsocks server on TCP and UDP of the same address
+func NewShadowSocksProxy(addr, cipher, password string) (*ShadowSocksListener, error) {
+	ciph, err := core.PickCipher(cipher, nil, password)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	udp, err := NewShadowSocksUDP(addr, ciph)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	sl := &ShadowSocksListener{l, udp, addr, make(chan struct{})}
+	log.Infof("ShadowSocks proxy listening at: %s", addr)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				select {
+				case <-sl.done:
+					return
+				default:
+					continue
+				}
+			}
+			c.(*net.TCPConn).SetKeepAlive(true)
+			go handleShadowSocks(ciph.StreamConn(c))
+		}
+	}()
+	return sl, nil
+}
+
+// Close stops accepting TCP and UDP, established connections and
+// UDP sessions are left to finish or time out
+func (l *ShadowSocksListener) Close() {
+	close(l.done)
+	l.Listener.Close()
+	l.udp.Close()
+}
+
+func (l *ShadowSocksListener) Address() string {
+	return l.address
+}
+
+// handleShadowSocks reads the target address sent by the client
+// in front of the decrypted stream
+func handleShadowSocks(conn net.Conn) {
+	target, err := socks.ReadAddr(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	tun.Add(NewShadowSocks(target, conn))
+}
+
+type ShadowSocksAdapter struct {
+	conn net.Conn
+	addr *C.Addr
+}
+
+func (s *ShadowSocksAdapter) Close() {
+	s.conn.Close()
+}
+
+func (s *ShadowSocksAdapter) Addr() *C.Addr {
+	return s.addr
+}
+
+func (s *ShadowSocksAdapter) Connect(proxy C.ProxyAdapter) {
+	go io.Copy(s.conn, proxy.ReadWriter())
+	io.Copy(proxy.ReadWriter(), s.conn)
+}
+
+func NewShadowSocks(target socks.Addr, conn net.Conn) *ShadowSocksAdapter {
+	return &ShadowSocksAdapter{
+		conn: conn,
+		addr: S.ParseSocksAddr(target),
+	}
+}
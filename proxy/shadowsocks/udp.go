@@ -0,0 +1,176 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	C "../../constant"
+	S "../socks"
+
+	"github.com/riobard/go-shadowsocks2/core"
+	"github.com/riobard/go-shadowsocks2/socks"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	udpBufferSize = 65535
+	udpTimeout    = 60 * time.Second
+)
+
+// natTable keeps a session for each pair of client and target
+type natTable struct {
+	sessions map[string]*UDPAdapter
+	lock     sync.Mutex
+}
+
+type UDPListener struct {
+	net.PacketConn
+	address string
+	done    chan struct{}
+}
+
+func NewShadowSocksUDP(addr string, cipher core.Cipher) (*UDPListener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ul := &UDPListener{cipher.PacketConn(pc), addr, make(chan struct{})}
+	log.Infof("ShadowSocks UDP listening at: %s", addr)
+	go ul.serve()
+	return ul, nil
+}
+
+// Close stops receiving, running sessions end when they time out
+func (l *UDPListener) Close() {
+	close(l.done)
+	l.PacketConn.Close()
+}
+
+func (l *UDPListener) Address() string {
+	return l.address
+}
+
+func (l *UDPListener) serve() {
+	nat := &natTable{sessions: map[string]*UDPAdapter{}}
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, src, err := l.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		// every packet starts with the target address
+		target := socks.SplitAddr(buf[:n])
+		if target == nil {
+			continue
+		}
+
+		packet := make([]byte, n-len(target))
+		copy(packet, buf[len(target):n])
+		nat.handle(l.PacketConn, src, target, packet)
+	}
+}
+
+func (nat *natTable) handle(pc net.PacketConn, src net.Addr, target socks.Addr, packet []byte) {
+	key := src.String() + "-" + target.String()
+
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+
+	session, ok := nat.sessions[key]
+	if !ok {
+		addr := S.ParseSocksAddr(target)
+		addr.NetWork = C.UDP
+
+		session = &UDPAdapter{
+			addr:    addr,
+			src:     src,
+			target:  target,
+			pc:      pc,
+			packets: make(chan []byte, 64),
+			close: func() {
+				nat.lock.Lock()
+				delete(nat.sessions, key)
+				nat.lock.Unlock()
+			},
+		}
+		nat.sessions[key] = session
+		tun.Add(session)
+	}
+
+	select {
+	case session.packets <- packet:
+	default:
+		// drop the packet when the upstream is too slow, reported on close
+		session.dropped.Add(1)
+	}
+}
+
+// UDPAdapter relays the packets of a client to one target,
+// replies are sent back through the shared server socket
+type UDPAdapter struct {
+	addr    *C.Addr
+	src     net.Addr
+	target  socks.Addr
+	pc      net.PacketConn
+	packets chan []byte
+	close   func()
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+func (u *UDPAdapter) Close() {
+	u.once.Do(func() {
+		u.close()
+		close(u.packets)
+		if n := u.dropped.Load(); n > 0 {
+			log.Warnf("ShadowSocks UDP session of %s dropped %d packets, the upstream is too slow", u.src.String(), n)
+		}
+	})
+}
+
+func (u *UDPAdapter) Addr() *C.Addr {
+	return u.addr
+}
+
+func (u *UDPAdapter) Connect(proxy C.ProxyAdapter) {
+	conn := proxy.Conn()
+	if conn == nil {
+		// REJECT
+		return
+	}
+
+	go func() {
+		for packet := range u.packets {
+			if _, err := conn.Write(packet); err != nil {
+				return
+			}
+			// a session only sending is still alive
+			conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		}
+	}()
+
+	buf := make([]byte, udpBufferSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		// replies carry the target address as their source
+		reply := bytes.Join([][]byte{u.target, buf[:n]}, []byte(""))
+		if _, err := u.pc.WriteTo(reply, u.src); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/riobard/go-shadowsocks2/socks"
+)
+
+type connAdapter struct {
+	conn net.Conn
+}
+
+func (a *connAdapter) ReadWriter() io.ReadWriter { return a.conn }
+func (a *connAdapter) Conn() net.Conn            { return a.conn }
+func (a *connAdapter) Close()                    { a.conn.Close() }
+
+func TestUDPAdapterReply(t *testing.T) {
+	// upstream echoes every packet back
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, udpBufferSize)
+		for {
+			n, from, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], from)
+		}
+	}()
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	upstream, err := net.Dial("udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := echo.LocalAddr().(*net.UDPAddr).Port
+	target := socks.Addr{socks.AtypIPv4, 127, 0, 0, 1, byte(port >> 8), byte(port)}
+	session := &UDPAdapter{
+		src:     client.LocalAddr(),
+		target:  target,
+		pc:      server,
+		packets: make(chan []byte, 1),
+		close:   func() {},
+	}
+	session.packets <- []byte("ping")
+	go session.Connect(&connAdapter{conn: upstream})
+	defer session.Close()
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, udpBufferSize)
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append(append([]byte{}, target...), "ping"...)
+	if !bytes.Equal(buf[:n], expected) {
+		t.Fatalf("expected %v, got %v", expected, buf[:n])
+	}
+}
@@ -0,0 +1,131 @@
+package sniffer
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+const (
+	recordTypeHandshake    = 0x16
+	handshakeClientHello   = 0x01
+	extensionServerName    = 0x0000
+	serverNameTypeHostName = 0x00
+)
+
+// reader walks a byte slice, any read past the end marks it broken
+type reader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *reader) skip(n int) {
+	if !r.ok || n > len(r.b) {
+		r.ok = false
+		return
+	}
+	r.b = r.b[n:]
+}
+
+func (r *reader) uint8() int {
+	if !r.ok || len(r.b) < 1 {
+		r.ok = false
+		return 0
+	}
+	v := int(r.b[0])
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) uint16() int {
+	return r.uint8()<<8 | r.uint8()
+}
+
+func (r *reader) bytes(n int) []byte {
+	if !r.ok || n > len(r.b) {
+		r.ok = false
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// parseTLS returns the server_name extension of a TLS ClientHello
+func parseTLS(b []byte) (string, bool) {
+	r := &reader{b: b, ok: true}
+
+	// record header: type, version, length
+	if r.uint8() != recordTypeHandshake {
+		return "", false
+	}
+	r.skip(2)
+	r = &reader{b: r.bytes(r.uint16()), ok: r.ok}
+
+	// handshake header: type, 24 bit length
+	if r.uint8() != handshakeClientHello {
+		return "", false
+	}
+	r.skip(3)
+
+	// version, random, session id, cipher suites, compression methods
+	r.skip(2 + 32)
+	r.skip(r.uint8())
+	r.skip(r.uint16())
+	r.skip(r.uint8())
+
+	extensions := &reader{b: r.bytes(r.uint16()), ok: r.ok}
+	for extensions.ok && len(extensions.b) >= 4 {
+		typ := extensions.uint16()
+		data := extensions.bytes(extensions.uint16())
+		if typ != extensionServerName {
+			continue
+		}
+
+		names := &reader{b: data, ok: extensions.ok}
+		names = &reader{b: names.bytes(names.uint16()), ok: names.ok}
+		for names.ok && len(names.b) >= 3 {
+			nameType := names.uint8()
+			name := names.bytes(names.uint16())
+			if names.ok && nameType == serverNameTypeHostName {
+				return validHost(string(name))
+			}
+		}
+	}
+	return "", false
+}
+
+// parseHTTP returns the Host header of an HTTP request
+func parseHTTP(b []byte) (string, bool) {
+	lines := bytes.Split(b, []byte("\r\n"))
+	if len(lines) < 2 || !bytes.Contains(lines[0], []byte(" HTTP/1.")) {
+		return "", false
+	}
+
+	for _, line := range lines[1:] {
+		if len(line) == 0 {
+			break
+		}
+
+		i := bytes.IndexByte(line, ':')
+		if i < 0 || !strings.EqualFold(string(line[:i]), "Host") {
+			continue
+		}
+
+		host := strings.TrimSpace(string(line[i+1:]))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return validHost(host)
+	}
+	return "", false
+}
+
+// validHost keeps domain names only, an IP gives nothing new
+func validHost(host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(host) != nil || strings.ContainsAny(host, " /\\") {
+		return "", false
+	}
+	return host, true
+}
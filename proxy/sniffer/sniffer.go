@@ -0,0 +1,161 @@
+package sniffer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	C "../../constant"
+
+	"github.com/riobard/go-shadowsocks2/socks"
+)
+
+const (
+	// a server-first protocol never sends anything, so waiting is bounded
+	sniffTimeout = 300 * time.Millisecond
+	// the largest TLS record, a ClientHello fits in one
+	maxRecordSize = 16384 + 5
+)
+
+var (
+	sniffer = &Sniffer{}
+	lock    sync.RWMutex
+)
+
+type Sniffer struct {
+	tlsPorts  map[string]bool
+	httpPorts map[string]bool
+	skip      []string
+}
+
+// Enabled reports whether any port is sniffed
+func (s *Sniffer) Enabled() bool {
+	return len(s.tlsPorts) != 0 || len(s.httpPorts) != 0
+}
+
+// Sniff peeks the first bytes the client sends to an IP-only target and,
+// when they are a TLS ClientHello or an HTTP request, replaces the target
+// with the domain name found in them. The returned conn must be used
+// instead of conn since the peeked bytes are buffered in it.
+func (s *Sniffer) Sniff(conn net.Conn, addr *C.Addr) net.Conn {
+	if addr.AddrType == socks.AtypDomainName {
+		return conn
+	}
+
+	var parse func([]byte) (string, bool)
+	switch {
+	case s.tlsPorts[addr.Port]:
+		parse = parseTLS
+	case s.httpPorts[addr.Port]:
+		parse = parseHTTP
+	default:
+		return conn
+	}
+
+	bufConn := &peekedConn{Conn: conn, r: bufio.NewReaderSize(conn, maxRecordSize)}
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	if _, err := bufConn.r.Peek(1); err != nil {
+		return bufConn
+	}
+	buf, _ := bufConn.r.Peek(bufConn.r.Buffered())
+
+	// a ClientHello may be split across reads, wait for the whole record
+	if len(buf) >= 5 && buf[0] == recordTypeHandshake {
+		length := int(buf[3])<<8 | int(buf[4])
+		if more, err := bufConn.r.Peek(5 + length); err == nil {
+			buf = more
+		}
+	}
+
+	host, ok := parse(buf)
+	if !ok || s.skipped(host) {
+		return bufConn
+	}
+
+	addr.Host = host
+	addr.AddrType = socks.AtypDomainName
+	return bufConn
+}
+
+func (s *Sniffer) skipped(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range s.skip {
+		switch {
+		case strings.HasPrefix(domain, "+."):
+			if host == domain[2:] || strings.HasSuffix(host, domain[1:]) {
+				return true
+			}
+		case strings.HasPrefix(domain, "."):
+			if strings.HasSuffix(host, domain) {
+				return true
+			}
+		case host == domain:
+			return true
+		}
+	}
+	return false
+}
+
+// NewSniffer sniffs TLS on tlsPorts and HTTP on httpPorts, domains in skip are
+// left as IP. A domain starting with "+." also matches itself and its
+// subdomains, one starting with "." only its subdomains.
+func NewSniffer(tlsPorts, httpPorts, skip []string) (*Sniffer, error) {
+	s := &Sniffer{}
+	var err error
+	if s.tlsPorts, err = parsePorts(tlsPorts); err != nil {
+		return nil, err
+	}
+	if s.httpPorts, err = parsePorts(httpPorts); err != nil {
+		return nil, err
+	}
+
+	for _, domain := range skip {
+		s.skip = append(s.skip, strings.ToLower(domain))
+	}
+	return s, nil
+}
+
+func parsePorts(ports []string) (map[string]bool, error) {
+	set := map[string]bool{}
+	for _, port := range ports {
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return nil, fmt.Errorf("invalid port %s", port)
+		}
+		set[port] = true
+	}
+	return set, nil
+}
+
+// Get returns the sniffer shared by SOCKS and HTTP inbounds
+func Get() *Sniffer {
+	lock.RLock()
+	defer lock.RUnlock()
+	return sniffer
+}
+
+// Set replaces the sniffer, nil turns sniffing off
+func Set(s *Sniffer) {
+	if s == nil {
+		s = &Sniffer{}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	sniffer = s
+}
+
+// peekedConn keeps the peeked bytes for the real handler
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (pc *peekedConn) Read(b []byte) (int, error) {
+	return pc.r.Read(b)
+}
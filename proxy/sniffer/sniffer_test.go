@@ -0,0 +1,80 @@
+package sniffer
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	C "../../constant"
+
+	"github.com/riobard/go-shadowsocks2/socks"
+)
+
+func ipAddr(port string) *C.Addr {
+	ip := net.ParseIP("1.2.3.4")
+	return &C.Addr{AddrType: socks.AtypIPv4, IP: &ip, Port: port}
+}
+
+// sniff runs write on the client side of a pipe and sniffs the server side
+func sniff(t *testing.T, s *Sniffer, addr *C.Addr, write func(net.Conn)) []byte {
+	client, server := net.Pipe()
+	defer client.Close()
+	go write(client)
+
+	conn := s.Sniff(server, addr)
+	client.Close()
+	rest, _ := ioutil.ReadAll(conn)
+	return rest
+}
+
+func TestSniffTLS(t *testing.T) {
+	s, _ := NewSniffer([]string{"443"}, nil, nil)
+	addr := ipAddr("443")
+	rest := sniff(t, s, addr, func(c net.Conn) {
+		tls.Client(c, &tls.Config{ServerName: "www.example.com"}).Handshake()
+	})
+
+	if addr.Host != "www.example.com" || addr.AddrType != socks.AtypDomainName {
+		t.Fatalf("expected www.example.com, got %q", addr.Host)
+	}
+	if len(rest) == 0 || rest[0] != recordTypeHandshake {
+		t.Fatal("the ClientHello should still be readable")
+	}
+}
+
+func TestSniffHTTP(t *testing.T) {
+	s, _ := NewSniffer(nil, []string{"80"}, nil)
+	addr := ipAddr("80")
+	request := "GET / HTTP/1.1\r\nHost: Example.com:80\r\n\r\n"
+	rest := sniff(t, s, addr, func(c net.Conn) {
+		c.Write([]byte(request))
+	})
+
+	if addr.Host != "example.com" {
+		t.Fatalf("expected example.com, got %q", addr.Host)
+	}
+	if string(rest) != request {
+		t.Fatalf("request changed: %q", rest)
+	}
+}
+
+func TestSniffSkip(t *testing.T) {
+	s, _ := NewSniffer(nil, []string{"80"}, []string{"+.example.com"})
+	addr := ipAddr("80")
+	sniff(t, s, addr, func(c net.Conn) {
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: a.example.com\r\n\r\n"))
+	})
+	if addr.Host != "" {
+		t.Fatalf("skipped domain should stay IP, got %q", addr.Host)
+	}
+
+	// other ports are not touched
+	addr = ipAddr("8080")
+	sniff(t, s, addr, func(c net.Conn) {
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: b.com\r\n\r\n"))
+	})
+	if addr.Host != "" {
+		t.Fatalf("port 8080 should not be sniffed, got %q", addr.Host)
+	}
+}
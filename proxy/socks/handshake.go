@@ -0,0 +1,229 @@
+package socks
+
+import (
+	"errors"
+	"io"
+
+	"../auth"
+
+	"github.com/riobard/go-shadowsocks2/socks"
+)
+
+// SOCKS methods and commands
+const (
+	socks4Version = 4
+	socks5Version = 5
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 1
+
+	userPassVersion = 1
+
+	socks4Granted  = 90
+	socks4Rejected = 91
+)
+
+var (
+	errVersion      = errors.New("unsupported SOCKS version")
+	errAuthMethod   = errors.New("no acceptable authentication method")
+	errAuthFailed   = errors.New("authentication failed")
+	errTooLong      = errors.New("SOCKS4 string too long")
+	successResponse = []byte{socks5Version, 0, 0, socks.AtypIPv4, 0, 0, 0, 0, 0, 0}
+	rejectResponse4 = []byte{0, socks4Rejected, 0, 0, 0, 0, 0, 0}
+)
+
+// handshake dispatches by the version byte to SOCKS4/4a or SOCKS5,
+// the authenticated user is returned with the target
+func handshake(rw io.ReadWriter) (socks.Addr, string, error) {
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return nil, "", err
+	}
+
+	switch buf[0] {
+	case socks4Version:
+		return handshake4(rw)
+	case socks5Version:
+		return handshake5(rw)
+	default:
+		return nil, "", errVersion
+	}
+}
+
+// handshake5 implements the server side of RFC 1928 with the username/password
+// authentication of RFC 1929, the version byte is already consumed
+func handshake5(rw io.ReadWriter) (socks.Addr, string, error) {
+	buf := make([]byte, 255)
+
+	// NMETHODS, METHODS
+	if _, err := io.ReadFull(rw, buf[:1]); err != nil {
+		return nil, "", err
+	}
+	methods := buf[:buf[0]]
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return nil, "", err
+	}
+
+	user := ""
+	authenticator := auth.Get()
+	if authenticator.Enabled() {
+		if !hasMethod(methods, methodUserPass) {
+			rw.Write([]byte{socks5Version, methodNoAcceptable})
+			return nil, "", errAuthMethod
+		}
+		if _, err := rw.Write([]byte{socks5Version, methodUserPass}); err != nil {
+			return nil, "", err
+		}
+
+		var err error
+		user, err = authenticate(rw, authenticator)
+		if err != nil {
+			return nil, "", err
+		}
+	} else if _, err := rw.Write([]byte{socks5Version, methodNoAuth}); err != nil {
+		return nil, "", err
+	}
+
+	// VER, CMD, RSV, DST.ADDR, DST.PORT
+	if _, err := io.ReadFull(rw, buf[:3]); err != nil {
+		return nil, "", err
+	}
+	if buf[0] != socks5Version {
+		return nil, "", errVersion
+	}
+	cmd := buf[1]
+	target, err := socks.ReadAddr(rw)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cmd != cmdConnect {
+		rw.Write([]byte{socks5Version, byte(socks.ErrCommandNotSupported), 0, socks.AtypIPv4, 0, 0, 0, 0, 0, 0})
+		return nil, "", socks.ErrCommandNotSupported
+	}
+
+	if _, err := rw.Write(successResponse); err != nil {
+		return nil, "", err
+	}
+	return target, user, nil
+}
+
+// authenticate reads VER, ULEN, UNAME, PLEN, PASSWD
+func authenticate(rw io.ReadWriter, authenticator *auth.Authenticator) (string, error) {
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(rw, buf[:2]); err != nil {
+		return "", err
+	}
+	if buf[0] != userPassVersion {
+		return "", errVersion
+	}
+
+	user := buf[:buf[1]]
+	if _, err := io.ReadFull(rw, user); err != nil {
+		return "", err
+	}
+	username := string(user)
+
+	if _, err := io.ReadFull(rw, buf[:1]); err != nil {
+		return "", err
+	}
+	password := buf[:buf[0]]
+	if _, err := io.ReadFull(rw, password); err != nil {
+		return "", err
+	}
+
+	if !authenticator.Verify(username, string(password)) {
+		rw.Write([]byte{userPassVersion, 1})
+		return "", errAuthFailed
+	}
+
+	if _, err := rw.Write([]byte{userPassVersion, 0}); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+func hasMethod(methods []byte, method byte) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// handshake4 implements SOCKS4 and the SOCKS4a extension, the version byte is
+// already consumed. SOCKS4 can't carry a password, so it's refused when
+// authentication is enabled.
+func handshake4(rw io.ReadWriter) (socks.Addr, string, error) {
+	// CD, DSTPORT, DSTIP
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return nil, "", err
+	}
+	cmd, port, ip := buf[0], buf[1:3], buf[3:7]
+
+	// USERID
+	if _, err := readString(rw); err != nil {
+		if err == errTooLong {
+			rw.Write(rejectResponse4)
+		}
+		return nil, "", err
+	}
+
+	var target socks.Addr
+	// SOCKS4a: DSTIP is 0.0.0.x and the domain follows USERID
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		host, err := readString(rw)
+		if err != nil {
+			if err == errTooLong {
+				rw.Write(rejectResponse4)
+			}
+			return nil, "", err
+		}
+		if len(host) == 0 {
+			rw.Write(rejectResponse4)
+			return nil, "", errors.New("invalid SOCKS4a domain")
+		}
+		target = append([]byte{socks.AtypDomainName, byte(len(host))}, host...)
+	} else {
+		target = append([]byte{socks.AtypIPv4}, ip...)
+	}
+	target = append(target, port...)
+
+	if cmd != cmdConnect {
+		rw.Write(rejectResponse4)
+		return nil, "", socks.ErrCommandNotSupported
+	}
+
+	if auth.Get().Enabled() {
+		rw.Write(rejectResponse4)
+		return nil, "", errAuthMethod
+	}
+
+	if _, err := rw.Write([]byte{0, socks4Granted, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, "", err
+	}
+	return target, "", nil
+}
+
+// readString reads a null-terminated string of at most 255 bytes
+func readString(r io.Reader) ([]byte, error) {
+	var str []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if b[0] == 0 {
+			return str, nil
+		}
+		if len(str) == 255 {
+			return nil, errTooLong
+		}
+		str = append(str, b[0])
+	}
+}
@@ -0,0 +1,145 @@
+package socks
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"../auth"
+
+	"github.com/riobard/go-shadowsocks2/socks"
+)
+
+func dialHandshake(request []byte, replyLen int) ([]byte, string, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	type result struct {
+		user string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		_, user, err := handshake(server)
+		server.Close()
+		ch <- result{user, err}
+	}()
+
+	go client.Write(request)
+	reply := make([]byte, replyLen)
+	n, _ := io.ReadFull(client, reply)
+	r := <-ch
+	return reply[:n], r.user, r.err
+}
+
+func TestHandshake_UserPass(t *testing.T) {
+	auth.Set(map[string]string{"alice": "secret"})
+	defer auth.Set(nil)
+
+	request := []byte{5, 1, methodUserPass}
+	request = append(request, 1, 5, 'a', 'l', 'i', 'c', 'e', 6, 's', 'e', 'c', 'r', 'e', 't')
+	request = append(request, 5, cmdConnect, 0, 1, 127, 0, 0, 1, 0, 80)
+
+	reply, user, err := dialHandshake(request, 2+2+len(successResponse))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "alice" {
+		t.Errorf("user should be alice, got %s", user)
+	}
+
+	if !bytes.Equal(reply[:4], []byte{5, methodUserPass, 1, 0}) {
+		t.Errorf("reply error: %v", reply)
+	}
+}
+
+func TestHandshake_AuthRequired(t *testing.T) {
+	auth.Set(map[string]string{"alice": "secret"})
+	defer auth.Set(nil)
+
+	reply, _, err := dialHandshake([]byte{5, 1, methodNoAuth}, 2)
+	if err != errAuthMethod || !bytes.Equal(reply, []byte{5, methodNoAcceptable}) {
+		t.Errorf("no auth should be rejected: %v %v", reply, err)
+	}
+
+	request := []byte{5, 1, methodUserPass, 1, 5, 'a', 'l', 'i', 'c', 'e', 1, 'x'}
+	reply, _, err = dialHandshake(request, 4)
+	if err != errAuthFailed || !bytes.Equal(reply, []byte{5, methodUserPass, 1, 1}) {
+		t.Errorf("wrong password should be rejected: %v %v", reply, err)
+	}
+}
+
+func TestHandshake_RequestVersion(t *testing.T) {
+	request := []byte{5, 1, methodNoAuth, 4, cmdConnect, 0, 1, 127, 0, 0, 1, 0, 80}
+	reply, _, err := dialHandshake(request, 2+len(successResponse))
+	if err != errVersion || !bytes.Equal(reply, []byte{5, methodNoAuth}) {
+		t.Errorf("a request of another version should be rejected: %v %v", reply, err)
+	}
+}
+
+func TestHandshake_Socks4a(t *testing.T) {
+	request := []byte{4, cmdConnect, 0, 80, 0, 0, 0, 1, 'u', 0}
+	request = append(request, "example.com"...)
+	request = append(request, 0)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write(request)
+	go io.Copy(io.Discard, client)
+
+	target, _, err := handshake(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := ParseSocksAddr(target)
+	if addr.Host != "example.com" || addr.Port != "80" || addr.AddrType != socks.AtypDomainName {
+		t.Errorf("target error: %v", addr)
+	}
+}
+
+func TestHandshake_Socks4aTooLong(t *testing.T) {
+	request := []byte{4, cmdConnect, 0, 80, 0, 0, 0, 1, 'u', 0}
+	request = append(request, bytes.Repeat([]byte{'a'}, 300)...)
+	request = append(request, 0)
+
+	reply, _, err := dialHandshake(request, 8)
+	if err != errTooLong || !bytes.Equal(reply, rejectResponse4) {
+		t.Errorf("a too long domain should be rejected: %v %v", reply, err)
+	}
+}
+
+func TestHandshake_Socks4(t *testing.T) {
+	request := []byte{4, cmdConnect, 1, 187, 93, 184, 216, 34, 'u', 0}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write(request)
+	replyCh := make(chan []byte, 1)
+	go func() {
+		reply := make([]byte, 8)
+		io.ReadFull(client, reply)
+		replyCh <- reply
+	}()
+
+	target, _, err := handshake(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := ParseSocksAddr(target)
+	if addr.IP == nil || addr.IP.String() != "93.184.216.34" || addr.Port != "443" || addr.AddrType != socks.AtypIPv4 {
+		t.Errorf("target error: %v", addr)
+	}
+
+	if reply := <-replyCh; !bytes.Equal(reply, []byte{0, socks4Granted, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("reply error: %v", reply)
+	}
+}
@@ -1,13 +1,13 @@
 package socks
 
 import (
-	"fmt"
 	"io"
 	"net"
 	"strconv"
 
 	C "../../constant"
 	"../../tunnel"
+	"../sniffer"
 
 	"github.com/riobard/go-shadowsocks2/socks"
 	log "github.com/sirupsen/logrus"
@@ -17,30 +17,60 @@ var (
 	tun = tunnel.GetInstance()
 )
 
-func NewSocksProxy(port string) {
-	l, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
-	defer l.Close()
+type SockListener struct {
+	net.Listener
+	address string
+	done    chan struct{}
+}
+
+func NewSocksProxy(addr string) (*SockListener, error) {
+	l, err := net.Listen("tcp", addr)
 	if err != nil {
-		return
+		return nil, err
 	}
-	log.Infof("SOCKS proxy :%s", port)
-	for {
-		c, err := l.Accept()
-		if err != nil {
-			continue
+
+	sl := &SockListener{l, addr, make(chan struct{})}
+	log.Infof("SOCKS proxy listening at: %s", addr)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				select {
+				case <-sl.done:
+					return
+				default:
+					continue
+				}
+			}
+			go HandleSocks(c)
 		}
-		go handleSocks(c)
-	}
+	}()
+	return sl, nil
 }
 
-func handleSocks(conn net.Conn) {
-	target, err := socks.Handshake(conn)
+// Close stops accepting, connections already accepted are left to finish
+func (l *SockListener) Close() {
+	close(l.done)
+	l.Listener.Close()
+}
+
+func (l *SockListener) Address() string {
+	return l.address
+}
+
+func HandleSocks(conn net.Conn) {
+	target, user, err := handshake(conn)
 	if err != nil {
 		conn.Close()
 		return
 	}
-	conn.(*net.TCPConn).SetKeepAlive(true)
-	tun.Add(NewSocks(target, conn))
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+	}
+	adapter := NewSocks(target, conn)
+	adapter.addr.User = user
+	adapter.conn = sniffer.Get().Sniff(conn, adapter.addr)
+	tun.Add(adapter)
 }
 
 type SocksAdapter struct {
@@ -61,7 +91,8 @@ func (s *SocksAdapter) Connect(proxy C.ProxyAdapter) {
 	io.Copy(proxy.ReadWriter(), s.conn)
 }
 
-func parseSocksAddr(target socks.Addr) *C.Addr {
+// ParseSocksAddr converts a SOCKS address to a TCP address, domain names are resolved
+func ParseSocksAddr(target socks.Addr) *C.Addr {
 	var host, port string
 	var ip net.IP
 
@@ -93,6 +124,6 @@ func parseSocksAddr(target socks.Addr) *C.Addr {
 func NewSocks(target socks.Addr, conn net.Conn) *SocksAdapter {
 	return &SocksAdapter{
 		conn: conn,
-		addr: parseSocksAddr(target),
+		addr: ParseSocksAddr(target),
 	}
 }
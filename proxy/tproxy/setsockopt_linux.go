@@ -0,0 +1,104 @@
+//go:build linux
+// +build linux
+
+package tproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	IPV6_TRANSPARENT     = 75 // from linux/include/uapi/linux/in6.h
+	IPV6_RECVORIGDSTADDR = 74 // from linux/include/uapi/linux/in6.h
+)
+
+func transparent(recvOrigDst bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var innerErr error
+		err := c.Control(func(fd uintptr) {
+			opts := [][2]int{
+				{syscall.SOL_SOCKET, syscall.SO_REUSEADDR},
+				{syscall.SOL_IP, syscall.IP_TRANSPARENT},
+				{syscall.SOL_IPV6, IPV6_TRANSPARENT},
+			}
+			if recvOrigDst {
+				opts = append(opts, [2]int{syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR}, [2]int{syscall.SOL_IPV6, IPV6_RECVORIGDSTADDR})
+			}
+
+			for _, opt := range opts {
+				err := syscall.SetsockoptInt(int(fd), opt[0], opt[1], 1)
+				// IPv6 options fail on IPv4 only sockets
+				if err != nil && opt[0] != syscall.SOL_IPV6 {
+					innerErr = err
+					return
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return innerErr
+	}
+}
+
+func listenTCP(address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: transparent(false)}
+	return lc.Listen(context.Background(), "tcp", address)
+}
+
+func listenUDP(address string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: transparent(true)}
+	pc, err := lc.ListenPacket(context.Background(), "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+func listenReply(addr *net.UDPAddr) (net.PacketConn, error) {
+	network := "udp6"
+	if addr.IP.To4() != nil {
+		network = "udp4"
+	}
+	lc := net.ListenConfig{Control: transparent(false)}
+	return lc.ListenPacket(context.Background(), network, addr.String())
+}
+
+// getOrigDst reads IP_ORIGDSTADDR or IPV6_ORIGDSTADDR from the ancillary data
+func getOrigDst(oob []byte) (*net.UDPAddr, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.SOL_IP && msg.Header.Type == syscall.IP_RECVORIGDSTADDR:
+			if len(msg.Data) < syscall.SizeofSockaddrInet4 {
+				continue
+			}
+			raw := (*syscall.RawSockaddrInet4)(unsafe.Pointer(&msg.Data[0]))
+			ip := net.IPv4(raw.Addr[0], raw.Addr[1], raw.Addr[2], raw.Addr[3])
+			return &net.UDPAddr{IP: ip, Port: ntohs(raw.Port)}, nil
+		case msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == IPV6_RECVORIGDSTADDR:
+			if len(msg.Data) < syscall.SizeofSockaddrInet6 {
+				continue
+			}
+			raw := (*syscall.RawSockaddrInet6)(unsafe.Pointer(&msg.Data[0]))
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, raw.Addr[:])
+			return &net.UDPAddr{IP: ip, Port: ntohs(raw.Port)}, nil
+		}
+	}
+	return nil, errors.New("original destination not found")
+}
+
+// ntohs converts a port stored in network byte order
+func ntohs(port uint16) int {
+	p := (*[2]byte)(unsafe.Pointer(&port))
+	return int(p[0])<<8 | int(p[1])
+}
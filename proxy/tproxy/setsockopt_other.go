@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package tproxy
+
+import (
+	"errors"
+	"net"
+)
+
+var errNotSupported = errors.New("tproxy is only supported on linux")
+
+func listenTCP(address string) (net.Listener, error) {
+	return nil, errNotSupported
+}
+
+func listenUDP(address string) (*net.UDPConn, error) {
+	return nil, errNotSupported
+}
+
+func listenReply(addr *net.UDPAddr) (net.PacketConn, error) {
+	return nil, errNotSupported
+}
+
+func getOrigDst(oob []byte) (*net.UDPAddr, error) {
+	return nil, errNotSupported
+}
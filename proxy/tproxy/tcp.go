@@ -0,0 +1,145 @@
+package tproxy
+
+import (
+	"io"
+	"net"
+	"strconv"
+
+	C "../../constant"
+	"../../tunnel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tun = tunnel.GetInstance()
+)
+
+type TProxyListener struct {
+	net.Listener
+	udp     *UDPListener
+	address string
+	done    chan struct{}
+}
+
+// NewTProxy serves TCP and UDP on the same address
+func NewTProxy(addr string) (*TProxyListener, error) {
+	l, err := listenTCP(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	udp, err := NewTProxyUDP(addr)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	tl := &TProxyListener{l, udp, addr, make(chan struct{})}
+	log.Infof("TProxy listening at: %s", addr)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				select {
+				case <-tl.done:
+					return
+				default:
+					continue
+				}
+			}
+			go handleTProxy(c, l.Addr().(*net.TCPAddr))
+		}
+	}()
+	return tl, nil
+}
+
+// Close stops accepting TCP and UDP, established connections and
+// UDP sessions are left to finish or time out
+func (l *TProxyListener) Close() {
+	close(l.done)
+	l.Listener.Close()
+	l.udp.Close()
+}
+
+func (l *TProxyListener) Address() string {
+	return l.address
+}
+
+// handleTProxy takes the original destination from the local address,
+// the socket is bound to it by IP_TRANSPARENT
+func handleTProxy(conn net.Conn, listen *net.TCPAddr) {
+	local := conn.LocalAddr().(*net.TCPAddr)
+	if isListener(local.IP, local.Port, listen.IP, listen.Port) {
+		log.Warnf("TProxy rejected %s, the destination is the listener itself", conn.RemoteAddr().String())
+		conn.Close()
+		return
+	}
+	conn.(*net.TCPConn).SetKeepAlive(true)
+	tun.Add(NewTProxyAdapter(parseAddr(C.TCP, local.IP, local.Port), conn))
+}
+
+type TProxyAdapter struct {
+	conn net.Conn
+	addr *C.Addr
+}
+
+func (t *TProxyAdapter) Close() {
+	t.conn.Close()
+}
+
+func (t *TProxyAdapter) Addr() *C.Addr {
+	return t.addr
+}
+
+func (t *TProxyAdapter) Connect(proxy C.ProxyAdapter) {
+	go io.Copy(t.conn, proxy.ReadWriter())
+	io.Copy(proxy.ReadWriter(), t.conn)
+}
+
+func NewTProxyAdapter(addr *C.Addr, conn net.Conn) *TProxyAdapter {
+	return &TProxyAdapter{
+		conn: conn,
+		addr: addr,
+	}
+}
+
+// isListener tells whether a destination is the listener itself, a client
+// sending to the port directly would make the tunnel loop back to it
+func isListener(ip net.IP, port int, listenIP net.IP, listenPort int) bool {
+	if port != listenPort {
+		return false
+	}
+	if !listenIP.IsUnspecified() {
+		return ip.Equal(listenIP)
+	}
+
+	// all interfaces
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(network C.NetWork, ip net.IP, port int) *C.Addr {
+	addrType := C.AtypIPv6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, addrType = ip4, C.AtypIPv4
+	}
+
+	return &C.Addr{
+		NetWork:  network,
+		AddrType: addrType,
+		IP:       &ip,
+		Port:     strconv.Itoa(port),
+	}
+}
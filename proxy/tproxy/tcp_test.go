@@ -0,0 +1,27 @@
+package tproxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsListener(t *testing.T) {
+	all := net.ParseIP("::")
+	cases := []struct {
+		ip       string
+		port     int
+		listenIP net.IP
+		expected bool
+	}{
+		{"127.0.0.1", 7893, all, true},
+		{"1.1.1.1", 7893, all, false},
+		{"127.0.0.1", 443, all, false},
+		{"192.168.1.1", 7893, net.ParseIP("192.168.1.1"), true},
+		{"192.168.1.2", 7893, net.ParseIP("192.168.1.1"), false},
+	}
+	for _, c := range cases {
+		if isListener(net.ParseIP(c.ip), c.port, c.listenIP, 7893) != c.expected {
+			t.Errorf("%s:%d should be the listener: %v", c.ip, c.port, c.expected)
+		}
+	}
+}
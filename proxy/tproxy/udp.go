@@ -0,0 +1,179 @@
+package tproxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	C "../../constant"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	udpBufferSize = 65535
+	udpTimeout    = 60 * time.Second
+)
+
+// natTable keeps a session for each pair of client and original destination
+type natTable struct {
+	sessions map[string]*UDPAdapter
+	lock     sync.Mutex
+}
+
+type UDPListener struct {
+	*net.UDPConn
+	address string
+	done    chan struct{}
+}
+
+func NewTProxyUDP(addr string) (*UDPListener, error) {
+	l, err := listenUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ul := &UDPListener{l, addr, make(chan struct{})}
+	log.Infof("TProxy UDP listening at: %s", addr)
+	go ul.serve()
+	return ul, nil
+}
+
+// Close stops receiving, sessions reply through their own sockets until they time out
+func (l *UDPListener) Close() {
+	close(l.done)
+	l.UDPConn.Close()
+}
+
+func (l *UDPListener) Address() string {
+	return l.address
+}
+
+func (l *UDPListener) serve() {
+	nat := &natTable{sessions: map[string]*UDPAdapter{}}
+	local := l.LocalAddr().(*net.UDPAddr)
+	buf := make([]byte, udpBufferSize)
+	oob := make([]byte, 1024)
+	for {
+		n, oobn, _, src, err := l.ReadMsgUDP(buf, oob)
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		dst, err := getOrigDst(oob[:oobn])
+		if err != nil {
+			log.Warnf("TProxy UDP original destination error: %s", err.Error())
+			continue
+		}
+		if isListener(dst.IP, dst.Port, local.IP, local.Port) {
+			log.Warnf("TProxy UDP rejected %s, the destination is the listener itself", src.String())
+			continue
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		nat.handle(src, dst, packet)
+	}
+}
+
+func (nat *natTable) handle(src *net.UDPAddr, dst *net.UDPAddr, packet []byte) {
+	key := src.String() + "-" + dst.String()
+
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+
+	session, ok := nat.sessions[key]
+	if !ok {
+		// replies must come from the original destination, so the socket
+		// used to answer the client is bound to that spoofed address
+		reply, err := listenReply(dst)
+		if err != nil {
+			log.Warnf("TProxy UDP reply %s error: %s", dst.String(), err.Error())
+			return
+		}
+
+		session = &UDPAdapter{
+			addr:    parseAddr(C.UDP, dst.IP, dst.Port),
+			src:     src,
+			reply:   reply,
+			packets: make(chan []byte, 64),
+			close: func() {
+				nat.lock.Lock()
+				delete(nat.sessions, key)
+				nat.lock.Unlock()
+			},
+		}
+		nat.sessions[key] = session
+		tun.Add(session)
+	}
+
+	select {
+	case session.packets <- packet:
+	default:
+		// drop the packet when the upstream is too slow, reported on close
+		session.dropped.Add(1)
+	}
+}
+
+// UDPAdapter forwards the datagrams of one client and destination pair
+type UDPAdapter struct {
+	addr    *C.Addr
+	src     *net.UDPAddr
+	reply   net.PacketConn
+	packets chan []byte
+	close   func()
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+func (u *UDPAdapter) Close() {
+	u.once.Do(func() {
+		u.close()
+		close(u.packets)
+		u.reply.Close()
+		if n := u.dropped.Load(); n > 0 {
+			log.Warnf("TProxy UDP session of %s dropped %d packets, the upstream is too slow", u.src.String(), n)
+		}
+	})
+}
+
+func (u *UDPAdapter) Addr() *C.Addr {
+	return u.addr
+}
+
+func (u *UDPAdapter) Connect(proxy C.ProxyAdapter) {
+	conn := proxy.Conn()
+	if conn == nil {
+		// REJECT
+		return
+	}
+
+	go func() {
+		for packet := range u.packets {
+			if _, err := conn.Write(packet); err != nil {
+				return
+			}
+			// a session only sending is still alive
+			conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		}
+	}()
+
+	buf := make([]byte, udpBufferSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if _, err := u.reply.WriteTo(buf[:n], u.src); err != nil {
+			return
+		}
+	}
+}
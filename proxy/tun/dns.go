@@ -0,0 +1,182 @@
+package tun
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"../../dialer"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+
+	dnsTTL        = 60
+	dnsTimeout    = 5 * time.Second
+	hostCacheSize = 4096
+)
+
+// hostCache remembers which domain an answered IP belongs to, so the
+// connections following a hijacked query can still match domain rules
+type hostCache struct {
+	hosts map[string]hostEntry
+	lock  sync.RWMutex
+}
+
+type hostEntry struct {
+	host    string
+	expired time.Time
+}
+
+func (hc *hostCache) Put(ip net.IP, host string) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	now := time.Now()
+	if len(hc.hosts) >= hostCacheSize {
+		for k, v := range hc.hosts {
+			if now.After(v.expired) {
+				delete(hc.hosts, k)
+			}
+		}
+	}
+	if len(hc.hosts) >= hostCacheSize {
+		hc.hosts = map[string]hostEntry{}
+	}
+	hc.hosts[ip.String()] = hostEntry{host: host, expired: now.Add(10 * time.Minute)}
+}
+
+func (hc *hostCache) Get(ip net.IP) (string, bool) {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+
+	entry, ok := hc.hosts[ip.String()]
+	if !ok || time.Now().After(entry.expired) {
+		return "", false
+	}
+	return entry.host, true
+}
+
+func newHostCache() *hostCache {
+	return &hostCache{hosts: map[string]hostEntry{}}
+}
+
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+	raw   []byte
+}
+
+// parseQuestion reads the first question of a query, names in queries
+// are never compressed
+func parseQuestion(msg []byte) (*dnsQuestion, error) {
+	if len(msg) < 12 || msg[2]&0x80 != 0 || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return nil, errors.New("not a dns query")
+	}
+
+	var labels []string
+	offset := 12
+	for {
+		if offset >= len(msg) {
+			return nil, errors.New("truncated dns question")
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		if length&0xc0 != 0 || offset+length > len(msg) {
+			return nil, errors.New("invalid dns name")
+		}
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+
+	if offset+4 > len(msg) {
+		return nil, errors.New("truncated dns question")
+	}
+
+	return &dnsQuestion{
+		name:  strings.ToLower(strings.Join(labels, ".")),
+		qtype: binary.BigEndian.Uint16(msg[offset : offset+2]),
+		raw:   msg[12 : offset+4],
+	}, nil
+}
+
+func buildAnswer(query []byte, q *dnsQuestion, rcode byte, ips []net.IP) []byte {
+	msg := make([]byte, 12, 512)
+	copy(msg, query[:2])
+	// QR, copy RD, RA
+	msg[2] = 0x80 | query[2]&0x01
+	msg[3] = 0x80 | rcode
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(ips)))
+	msg = append(msg, q.raw...)
+
+	for _, ip := range ips {
+		rr := make([]byte, 12)
+		// pointer to the question name
+		binary.BigEndian.PutUint16(rr[0:], 0xc00c)
+		binary.BigEndian.PutUint16(rr[2:], q.qtype)
+		binary.BigEndian.PutUint16(rr[4:], dnsClassIN)
+		binary.BigEndian.PutUint32(rr[6:], dnsTTL)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(ip)))
+		msg = append(append(msg, rr...), ip...)
+	}
+	return msg
+}
+
+// resolveQuery answers A and AAAA queries with the system DNS servers,
+// queried through the dialer so they don't loop back into the TUN,
+// other types get an empty answer
+func resolveQuery(query []byte, cache *hostCache) ([]byte, error) {
+	q, err := parseQuestion(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.qtype != dnsTypeA && q.qtype != dnsTypeAAAA {
+		return buildAnswer(query, q, dnsRcodeSuccess, nil), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	network := "ip4"
+	if q.qtype == dnsTypeAAAA {
+		network = "ip6"
+	}
+
+	ips, err := dialer.Resolver().LookupIP(ctx, network, q.name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return buildAnswer(query, q, dnsRcodeNXDomain, nil), nil
+		}
+		return buildAnswer(query, q, dnsRcodeServFail, nil), nil
+	}
+
+	answers := []net.IP{}
+	for _, ip := range ips {
+		if q.qtype == dnsTypeA {
+			ip = ip.To4()
+		} else {
+			ip = ip.To16()
+		}
+		if ip == nil {
+			continue
+		}
+		cache.Put(ip, q.name)
+		answers = append(answers, ip)
+	}
+	return buildAnswer(query, q, dnsRcodeSuccess, answers), nil
+}
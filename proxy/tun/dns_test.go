@@ -0,0 +1,50 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func query(name string, qtype uint16) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, 1)
+	return msg
+}
+
+func TestResolveQuery(t *testing.T) {
+	cache := newHostCache()
+	answer, err := resolveQuery(query("localhost", dnsTypeA), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if answer[0] != 0x12 || answer[1] != 0x34 || answer[2]&0x80 == 0 {
+		t.Fatal("answer header error")
+	}
+
+	count := binary.BigEndian.Uint16(answer[6:8])
+	if count == 0 {
+		t.Fatal("localhost should be resolved")
+	}
+
+	ip := net.IP(answer[len(answer)-4:])
+	if host, ok := cache.Get(ip); !ok || host != "localhost" {
+		t.Errorf("%s should be cached as localhost", ip.String())
+	}
+
+	// other types get an empty answer
+	answer, _ = resolveQuery(query("localhost", 16), cache)
+	if binary.BigEndian.Uint16(answer[6:8]) != 0 || answer[3]&0x0f != dnsRcodeSuccess {
+		t.Error("TXT should get an empty answer")
+	}
+
+	if _, err := resolveQuery([]byte{0, 1, 0x80}, cache); err == nil {
+		t.Error("invalid query should fail")
+	}
+}
@@ -0,0 +1,142 @@
+package tun
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	C "../../constant"
+	"../../tunnel"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	udpBufferSize = 65535
+	udpTimeout    = 60 * time.Second
+)
+
+var (
+	tun   = tunnel.GetInstance()
+	hosts = newHostCache()
+)
+
+type TunAdapter struct {
+	conn net.Conn
+	addr *C.Addr
+}
+
+func (t *TunAdapter) Close() {
+	t.conn.Close()
+}
+
+func (t *TunAdapter) Addr() *C.Addr {
+	return t.addr
+}
+
+func (t *TunAdapter) Connect(proxy C.ProxyAdapter) {
+	go io.Copy(t.conn, proxy.ReadWriter())
+	io.Copy(proxy.ReadWriter(), t.conn)
+}
+
+func NewTunAdapter(addr *C.Addr, conn net.Conn) *TunAdapter {
+	return &TunAdapter{
+		conn: conn,
+		addr: addr,
+	}
+}
+
+// UDPAdapter relays the datagrams of one flow, the conn from the
+// userspace stack is already bound to the client and destination
+type UDPAdapter struct {
+	conn net.Conn
+	addr *C.Addr
+}
+
+func (u *UDPAdapter) Close() {
+	u.conn.Close()
+}
+
+func (u *UDPAdapter) Addr() *C.Addr {
+	return u.addr
+}
+
+func (u *UDPAdapter) Connect(proxy C.ProxyAdapter) {
+	conn := proxy.Conn()
+	if conn == nil {
+		// REJECT
+		return
+	}
+
+	go copyPacket(conn, u.conn)
+	copyPacket(u.conn, conn)
+}
+
+func NewUDPAdapter(addr *C.Addr, conn net.Conn) *UDPAdapter {
+	return &UDPAdapter{
+		conn: conn,
+		addr: addr,
+	}
+}
+
+func copyPacket(dst net.Conn, src net.Conn) {
+	buf := make([]byte, udpBufferSize)
+	for {
+		src.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// handleDNS answers every query of the flow by itself until it's idle
+func handleDNS(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, udpBufferSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			answer, err := resolveQuery(query, hosts)
+			if err != nil {
+				log.Warnf("TUN DNS hijack error: %s", err.Error())
+				return
+			}
+			conn.Write(answer)
+		}()
+	}
+}
+
+// parseAddr restores the domain of an IP answered by the DNS hijack
+func parseAddr(network C.NetWork, ip net.IP, port uint16) *C.Addr {
+	addrType := C.AtypIPv6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, addrType = ip4, C.AtypIPv4
+	}
+
+	addr := &C.Addr{
+		NetWork:  network,
+		AddrType: addrType,
+		IP:       &ip,
+		Port:     strconv.Itoa(int(port)),
+	}
+
+	if host, ok := hosts.Get(ip); ok {
+		addr.AddrType = C.AtypDomainName
+		addr.Host = host
+	}
+	return addr
+}
@@ -0,0 +1,121 @@
+//go:build linux
+// +build linux
+
+package tun
+
+import (
+	"fmt"
+	"net"
+
+	C "../../constant"
+
+	log "github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/link/rawfile"
+	tundev "gvisor.dev/gvisor/pkg/tcpip/link/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	nicID = 1
+
+	// from gvisor's tcpip/sample/tun_tcp_connect
+	tcpReceiveWindow = 0
+	tcpMaxInFlight   = 2048
+)
+
+// NewTunProxy opens an existing TUN device, the address and routes of the
+// device are configured outside of clash, e.g.
+// ip tuntap add mode tun dev clash0 && ip addr add 198.18.0.1/16 dev clash0 && ip link set clash0 up
+func NewTunProxy(device string, dnsHijack bool) {
+	s, err := newStack(device, dnsHijack)
+	if err != nil {
+		log.Errorf("TUN %s error: %s", device, err.Error())
+		return
+	}
+	log.Infof("TUN %s", device)
+	s.Wait()
+}
+
+func newStack(device string, dnsHijack bool) (*stack.Stack, error) {
+	mtu, err := rawfile.GetMTU(device)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := tundev.Open(device)
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := fdbased.New(&fdbased.Options{FDs: []int{fd}, MTU: mtu})
+	if err != nil {
+		return nil, err
+	}
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		return nil, fmt.Errorf("create NIC: %s", err.String())
+	}
+
+	// accept packets for any destination and answer from any source address,
+	// the destination of each flow is the original target
+	if err := s.SetPromiscuousMode(nicID, true); err != nil {
+		return nil, fmt.Errorf("promiscuous mode: %s", err.String())
+	}
+	if err := s.SetSpoofing(nicID, true); err != nil {
+		return nil, fmt.Errorf("spoofing: %s", err.String())
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		{Destination: header.IPv6EmptySubnet, NIC: nicID},
+	})
+
+	tcpForwarder := tcp.NewForwarder(s, tcpReceiveWindow, tcpMaxInFlight, func(r *tcp.ForwarderRequest) {
+		var wq waiter.Queue
+		ep, err := r.CreateEndpoint(&wq)
+		if err != nil {
+			r.Complete(true)
+			return
+		}
+		r.Complete(false)
+
+		id := r.ID()
+		addr := parseAddr(C.TCP, net.IP(id.LocalAddress.AsSlice()), id.LocalPort)
+		tun.Add(NewTunAdapter(addr, gonet.NewTCPConn(&wq, ep)))
+	})
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpForwarder.HandlePacket)
+
+	udpForwarder := udp.NewForwarder(s, func(r *udp.ForwarderRequest) {
+		var wq waiter.Queue
+		ep, err := r.CreateEndpoint(&wq)
+		if err != nil {
+			return
+		}
+
+		conn := gonet.NewUDPConn(&wq, ep)
+		id := r.ID()
+		if dnsHijack && id.LocalPort == 53 {
+			go handleDNS(conn)
+			return
+		}
+
+		addr := parseAddr(C.UDP, net.IP(id.LocalAddress.AsSlice()), id.LocalPort)
+		tun.Add(NewUDPAdapter(addr, conn))
+	})
+	s.SetTransportProtocolHandler(udp.ProtocolNumber, udpForwarder.HandlePacket)
+
+	return s, nil
+}
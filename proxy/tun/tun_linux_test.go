@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package tun
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestTunDNSHijack creates a TUN device inside an isolated network namespace:
+// CLASH_NETNS=1 unshare -rn go test ./proxy/tun/
+func TestTunDNSHijack(t *testing.T) {
+	if os.Getenv("CLASH_NETNS") == "" {
+		t.Skip("CLASH_NETNS is not set")
+	}
+
+	run := func(name string, args ...string) {
+		if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %v: %s %s", name, args, err.Error(), out)
+		}
+	}
+
+	run("ip", "tuntap", "add", "mode", "tun", "dev", "clash0")
+	run("ip", "addr", "add", "198.18.0.1/30", "dev", "clash0")
+	run("ip", "link", "set", "clash0", "up")
+
+	s, err := newStack("clash0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp", "198.18.0.2:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(query("localhost", dnsTypeA))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n < 16 || !net.IP(buf[n-4:n]).Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Error("localhost should be answered by the hijack")
+	}
+}
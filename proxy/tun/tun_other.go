@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package tun
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+func NewTunProxy(device string, dnsHijack bool) {
+	log.Errorf("TUN %s error: TUN is only supported on linux", device)
+}
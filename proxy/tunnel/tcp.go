@@ -0,0 +1,172 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	C "../../constant"
+	"../../dialer"
+	T "../../tunnel"
+
+	"github.com/riobard/go-shadowsocks2/socks"
+	log "github.com/sirupsen/logrus"
+)
+
+const resolveTimeout = 5 * time.Second
+
+var (
+	tun = T.GetInstance()
+)
+
+// TunnelListener forwards everything received on a local address to a fixed target
+type TunnelListener struct {
+	listener net.Listener
+	udp      *UDPListener
+	address  string
+	done     chan struct{}
+}
+
+// NewTunnel listens on addr for network "tcp", "udp" or "tcp/udp",
+// proxy is used instead of the rules when it is not empty
+func NewTunnel(network, addr, target, proxy string) (*TunnelListener, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, err
+	}
+
+	switch network {
+	case "tcp", "udp", "tcp/udp":
+	default:
+		return nil, &net.AddrError{Err: "unknown network", Addr: network}
+	}
+
+	tl := &TunnelListener{address: addr, done: make(chan struct{})}
+	if network != "udp" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		tl.listener = l
+		go tl.serve(target, proxy)
+	}
+
+	if network != "tcp" {
+		udp, err := NewTunnelUDP(addr, target, proxy)
+		if err != nil {
+			tl.Close()
+			return nil, err
+		}
+		tl.udp = udp
+	}
+
+	log.Infof("Tunnel %s listening at: %s -> %s", network, addr, target)
+	return tl, nil
+}
+
+func (l *TunnelListener) serve(target, proxy string) {
+	for {
+		c, err := l.listener.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+				continue
+			}
+		}
+		c.(*net.TCPConn).SetKeepAlive(true)
+		tun.Add(NewTunnelAdapter(parseAddr(C.TCP, target), proxy, c))
+	}
+}
+
+// Close stops accepting, established connections and UDP sessions
+// are left to finish or time out
+func (l *TunnelListener) Close() {
+	close(l.done)
+	if l.listener != nil {
+		l.listener.Close()
+	}
+	if l.udp != nil {
+		l.udp.Close()
+	}
+}
+
+func (l *TunnelListener) Address() string {
+	return l.address
+}
+
+type TunnelAdapter struct {
+	conn    net.Conn
+	addr    *C.Addr
+	proxy   string
+	resolve sync.Once
+}
+
+func (t *TunnelAdapter) Close() {
+	t.conn.Close()
+}
+
+// Addr resolves the target on the goroutine handling the connection
+func (t *TunnelAdapter) Addr() *C.Addr {
+	t.resolve.Do(func() { resolveAddr(t.addr) })
+	return t.addr
+}
+
+func (t *TunnelAdapter) ProxyName() string {
+	return t.proxy
+}
+
+func (t *TunnelAdapter) Connect(proxy C.ProxyAdapter) {
+	go io.Copy(t.conn, proxy.ReadWriter())
+	io.Copy(proxy.ReadWriter(), t.conn)
+}
+
+func NewTunnelAdapter(addr *C.Addr, proxy string, conn net.Conn) *TunnelAdapter {
+	return &TunnelAdapter{
+		conn:  conn,
+		addr:  addr,
+		proxy: proxy,
+	}
+}
+
+// parseAddr builds the fixed target without resolving it, a domain name is
+// resolved by resolveAddr on every connection so that rules on both the
+// domain and the IP apply
+func parseAddr(network C.NetWork, target string) *C.Addr {
+	host, port, _ := net.SplitHostPort(target)
+
+	addrType := socks.AtypIPv4
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		addrType = socks.AtypDomainName
+	case ip.To4() == nil:
+		addrType = socks.AtypIPv6
+	}
+
+	addr := &C.Addr{
+		NetWork:  network,
+		AddrType: addrType,
+		Host:     host,
+		Port:     port,
+	}
+	if ip != nil {
+		addr.IP = &ip
+	}
+	return addr
+}
+
+// resolveAddr looks up the IP of a domain target, it's left empty on failure
+func resolveAddr(addr *C.Addr) {
+	if addr.AddrType != socks.AtypDomainName {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	if ips, err := dialer.Resolver().LookupIPAddr(ctx, addr.Host); err == nil && len(ips) > 0 {
+		addr.IP = &ips[0].IP
+	}
+}
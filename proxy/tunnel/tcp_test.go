@@ -0,0 +1,28 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+
+	C "../../constant"
+)
+
+func TestTunnelAdapterResolve(t *testing.T) {
+	addr := parseAddr(C.TCP, "localhost:80")
+	if addr.IP != nil {
+		t.Fatal("the target should not be resolved by the accept loop")
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	adapter := NewTunnelAdapter(addr, "", server)
+	if ip := adapter.Addr().IP; ip == nil || !ip.IsLoopback() {
+		t.Fatalf("localhost should be resolved by Addr, got %v", ip)
+	}
+
+	addr = parseAddr(C.UDP, "[::1]:53")
+	if addr.IP == nil || addr.IP.String() != "::1" {
+		t.Fatalf("an IP target needs no lookup, got %v", addr.IP)
+	}
+}
@@ -0,0 +1,165 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	C "../../constant"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	udpBufferSize = 65535
+	udpTimeout    = 60 * time.Second
+)
+
+// natTable keeps a session for each client
+type natTable struct {
+	sessions map[string]*UDPAdapter
+	lock     sync.Mutex
+}
+
+type UDPListener struct {
+	net.PacketConn
+	target string
+	proxy  string
+	done   chan struct{}
+}
+
+func NewTunnelUDP(addr, target, proxy string) (*UDPListener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ul := &UDPListener{pc, target, proxy, make(chan struct{})}
+	go ul.serve()
+	return ul, nil
+}
+
+// Close stops receiving, running sessions end when they time out
+func (l *UDPListener) Close() {
+	close(l.done)
+	l.PacketConn.Close()
+}
+
+func (l *UDPListener) serve() {
+	nat := &natTable{sessions: map[string]*UDPAdapter{}}
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, src, err := l.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		nat.handle(l, src, packet)
+	}
+}
+
+func (nat *natTable) handle(l *UDPListener, src net.Addr, packet []byte) {
+	key := src.String()
+
+	nat.lock.Lock()
+	defer nat.lock.Unlock()
+
+	session, ok := nat.sessions[key]
+	if !ok {
+		session = &UDPAdapter{
+			addr:    parseAddr(C.UDP, l.target),
+			proxy:   l.proxy,
+			src:     src,
+			pc:      l.PacketConn,
+			packets: make(chan []byte, 64),
+			close: func() {
+				nat.lock.Lock()
+				delete(nat.sessions, key)
+				nat.lock.Unlock()
+			},
+		}
+		nat.sessions[key] = session
+		tun.Add(session)
+	}
+
+	select {
+	case session.packets <- packet:
+	default:
+		// drop the packet when the upstream is too slow, reported on close
+		session.dropped.Add(1)
+	}
+}
+
+// UDPAdapter relays the packets of a client to the target,
+// replies are sent back through the shared local socket
+type UDPAdapter struct {
+	addr    *C.Addr
+	proxy   string
+	src     net.Addr
+	pc      net.PacketConn
+	packets chan []byte
+	close   func()
+	once    sync.Once
+	dropped atomic.Uint64
+	resolve sync.Once
+}
+
+func (u *UDPAdapter) Close() {
+	u.once.Do(func() {
+		u.close()
+		close(u.packets)
+		if n := u.dropped.Load(); n > 0 {
+			log.Warnf("Tunnel UDP session of %s dropped %d packets, the upstream is too slow", u.src.String(), n)
+		}
+	})
+}
+
+// Addr resolves the target on the goroutine handling the session,
+// not on the read loop holding the NAT table
+func (u *UDPAdapter) Addr() *C.Addr {
+	u.resolve.Do(func() { resolveAddr(u.addr) })
+	return u.addr
+}
+
+func (u *UDPAdapter) ProxyName() string {
+	return u.proxy
+}
+
+func (u *UDPAdapter) Connect(proxy C.ProxyAdapter) {
+	conn := proxy.Conn()
+	if conn == nil {
+		// REJECT
+		return
+	}
+
+	go func() {
+		for packet := range u.packets {
+			if _, err := conn.Write(packet); err != nil {
+				return
+			}
+			// a session only sending is still alive
+			conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		}
+	}()
+
+	buf := make([]byte, udpBufferSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if _, err := u.pc.WriteTo(buf[:n], u.src); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	C "./constant"
+	"./hub/executor"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadDelay waits for editors to finish writing before a reload,
+// every change within it restarts the wait
+const reloadDelay = 500 * time.Millisecond
+
+// watchConfig reloads the config when the file changes or on a SIGHUP of hupCh
+func watchConfig(hupCh chan os.Signal) {
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	// SIGHUP reloads the file even when it's unchanged
+	go func() {
+		for range hupCh {
+			log.Infoln("SIGHUP received, reload config")
+			reloadConfig(true)
+		}
+	}()
+
+	// watch the directory, editors often replace the file instead of writing it
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(C.ConfigPath))
+	}
+	if err != nil {
+		log.Warnf("Watch config error, only SIGHUP reloads it: %s", err.Error())
+	} else {
+		go func() {
+			for {
+				select {
+				case event, ok := <-watcher.Events:
+					if !ok {
+						return
+					}
+					if filepath.Clean(event.Name) == C.ConfigPath && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+						notify()
+					}
+				case err, ok := <-watcher.Errors:
+					if !ok {
+						return
+					}
+					log.Warnf("Watch config error: %s", err.Error())
+				}
+			}
+		}()
+	}
+
+	for range trigger {
+		timer := time.NewTimer(reloadDelay)
+	wait:
+		for {
+			select {
+			case <-trigger:
+				timer.Reset(reloadDelay)
+			case <-timer.C:
+				break wait
+			}
+		}
+		reloadConfig(false)
+	}
+}
+
+// reloadConfig applies the config file, the running config is kept when it's invalid
+func reloadConfig(force bool) {
+	if err := executor.ApplyFile(force); err != nil {
+		log.Errorf("Reload config error, keep the running config:\n%s", err.Error())
+	}
+}
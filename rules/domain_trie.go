@@ -0,0 +1,64 @@
+package rules
+
+import (
+	"strings"
+)
+
+// DomainTrie indexes domain suffixes by their labels in reverse order,
+// so google.com is stored as com -> google
+type DomainTrie struct {
+	root *domainNode
+}
+
+type domainNode struct {
+	children map[string]*domainNode
+	value    int
+	marked   bool
+}
+
+func newDomainNode() *domainNode {
+	return &domainNode{children: map[string]*domainNode{}}
+}
+
+// Insert keeps the smallest value when a suffix is inserted twice
+func (dt *DomainTrie) Insert(suffix string, value int) {
+	node := dt.root
+	labels := strings.Split(suffix, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := node.children[labels[i]]
+		if !ok {
+			child = newDomainNode()
+			node.children[labels[i]] = child
+		}
+		node = child
+	}
+
+	if !node.marked || value < node.value {
+		node.value = value
+		node.marked = true
+	}
+}
+
+// Search returns the smallest value of all suffixes matching domain
+func (dt *DomainTrie) Search(domain string) (int, bool) {
+	node := dt.root
+	value, found := 0, false
+	end := len(domain)
+	for end >= 0 {
+		start := strings.LastIndexByte(domain[:end], '.')
+		child, ok := node.children[domain[start+1:end]]
+		if !ok {
+			break
+		}
+		node = child
+		if node.marked && (!found || node.value < value) {
+			value, found = node.value, true
+		}
+		end = start
+	}
+	return value, found
+}
+
+func NewDomainTrie() *DomainTrie {
+	return &DomainTrie{root: newDomainNode()}
+}
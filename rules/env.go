@@ -0,0 +1,177 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+
+	C "../constant"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Env is what the rules of a config are built with. The databases it opens
+// are only used by the running rules after Commit, a rejected config
+// leaves them alone
+type Env struct {
+	// Providers are looked up by RULE-SET
+	Providers map[string]*RuleProvider
+	// GeoIP is the source of the Country database of GEOIP rules
+	GeoIP GeoIPSource
+	// ASNPath is the GeoLite2-ASN database of IP-ASN rules
+	ASNPath string
+	// Check leaves a missing database that can be downloaded alone
+	Check bool
+
+	// base is the env of the config a rule set belongs to
+	base *Env
+
+	// rule sets are reloaded in the background, even after Commit
+	lock sync.Mutex
+	mmdb *geoip2.Reader
+	asn  *geoip2.Reader
+}
+
+// ruleSet is the env of the rules of a rule set, they share the databases
+// of the config but can't refer to other rule sets
+func (e *Env) ruleSet() *Env {
+	if e == nil {
+		return &Env{}
+	}
+	return &Env{base: e}
+}
+
+func (e *Env) providers() map[string]*RuleProvider {
+	if e == nil {
+		return nil
+	}
+	return e.Providers
+}
+
+func (e *Env) asnPath() string {
+	if e.ASNPath == "" {
+		return C.ASNPath
+	}
+	return e.ASNPath
+}
+
+// loadMMDB reuses the running database when the path is the same,
+// a missing one is downloaded when the source allows it. Like loadASN
+// it hands a database opened after Commit to the running rules
+func (e *Env) loadMMDB() error {
+	if e.base != nil {
+		return e.base.loadMMDB()
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.mmdb != nil {
+		return nil
+	}
+
+	path := e.GeoIP.path()
+	mmdbLock.RLock()
+	if mmdbPath == path {
+		e.mmdb = mmdb
+	}
+	mmdbLock.RUnlock()
+	if e.mmdb != nil {
+		return nil
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if !e.GeoIP.downloadable() {
+			return fmt.Errorf("can't find MMDB %s, set geoip-url or geoip-license-key to download it", path)
+		}
+		if e.Check {
+			return nil
+		}
+		log.Info("Can't find MMDB, start download")
+		db, err := fetchGeoIP(e.GeoIP)
+		if err != nil {
+			return err
+		}
+		e.publishMMDB(db)
+		return nil
+	}
+
+	db, err := readMMDB(path)
+	if err != nil {
+		return fmt.Errorf("can't load MMDB %s: %s", path, err.Error())
+	}
+	e.publishMMDB(db)
+	return nil
+}
+
+func (e *Env) publishMMDB(db *geoip2.Reader) {
+	e.mmdb = db
+
+	mmdbLock.Lock()
+	if mmdbEnv == e {
+		mmdb, mmdbPath = db, e.GeoIP.path()
+	}
+	mmdbLock.Unlock()
+}
+
+// loadASN hands a database opened after Commit to the running rules,
+// a rule set may bring the first IP-ASN rule with an update
+func (e *Env) loadASN() error {
+	if e.base != nil {
+		return e.base.loadASN()
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.asn != nil {
+		return nil
+	}
+
+	path := e.asnPath()
+	asnLock.RLock()
+	if asnPath == path {
+		e.asn = asndb
+	}
+	asnLock.RUnlock()
+	if e.asn != nil {
+		return nil
+	}
+
+	db, err := readMMDB(path)
+	if err != nil {
+		return fmt.Errorf("can't load ASN database %s: %s", path, err.Error())
+	}
+	e.asn = db
+
+	asnLock.Lock()
+	if asnEnv == e {
+		asndb = db
+	}
+	asnLock.Unlock()
+	return nil
+}
+
+// Commit hands the databases of env to the running rules,
+// it's called once the rules built with env replace the old ones
+func (e *Env) Commit() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	asnLock.Lock()
+	// the old rules are swapped out, nothing looks up the old reader anymore
+	if asndb != nil && asndb != e.asn {
+		asndb.Close()
+	}
+	asndb, asnPath, asnEnv = e.asn, e.asnPath(), e
+	asnLock.Unlock()
+
+	// swapped before SetGeoIP starts the updates of the new source
+	if e.mmdb != nil {
+		swapMMDB(e.mmdb, e.GeoIP.path())
+	}
+	mmdbLock.Lock()
+	mmdbEnv = e
+	mmdbLock.Unlock()
+	SetGeoIP(e.GeoIP)
+}
@@ -1,23 +1,29 @@
 package rules
 
 import (
+	"fmt"
 	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/oschwald/geoip2-golang"
 
 	C "../constant"
-
-	log "github.com/sirupsen/logrus"
 )
 
-var mmdb *geoip2.Reader
+var (
+	asndb   *geoip2.Reader
+	asnPath string
+	// asnEnv is the env committed last
+	asnEnv  *Env
+	asnLock sync.RWMutex
+)
 
-func init() {
-	var err error
-	mmdb, err = geoip2.Open(C.MMDBPath)
-	if err != nil {
-		log.Fatalf("Can't load mmdb: %s", err.Error())
-	}
+func currentASN() *geoip2.Reader {
+	asnLock.RLock()
+	defer asnLock.RUnlock()
+	return asndb
 }
 
 type GEOIP struct {
@@ -39,7 +45,12 @@ func (g *GEOIP) IsMatch(addr *C.Addr) bool {
 		return false
 	}
 
-	record, _ := mmdb.Country(ip)
+	// the database may be swapped by an update
+	db := currentMMDB()
+	if db == nil {
+		return false
+	}
+	record, _ := db.Country(ip)
 	return record.Country.ISOCode == g.country
 }
 
@@ -51,9 +62,78 @@ func (g *GEOIP) Payload() string {
 	return g.country
 }
 
-func NewGEOIP(country string, adapter string) *GEOIP {
+// NewGEOIP opens the Country database of env, without one the database
+// is only used when the running rules have the same one
+func NewGEOIP(country string, adapter string, env *Env) (*GEOIP, error) {
+	if env == nil {
+		env = &Env{}
+	}
+	if err := env.loadMMDB(); err != nil {
+		return nil, err
+	}
+
 	return &GEOIP{
 		country: country,
 		adapter: adapter,
+	}, nil
+}
+
+type IPASN struct {
+	asn     uint
+	adapter string
+}
+
+func (i *IPASN) RuleType() C.RuleType {
+	return C.IPASN
+}
+
+func (i *IPASN) IsMatch(addr *C.Addr) bool {
+	if addr.IP == nil {
+		return false
+	}
+
+	ip, ok := netip.AddrFromSlice(*addr.IP)
+	if !ok {
+		return false
+	}
+
+	// the database is replaced along with the rules
+	db := currentASN()
+	if db == nil {
+		return false
 	}
+	record, err := db.ASN(ip.Unmap())
+	if err != nil {
+		return false
+	}
+	return record.AutonomousSystemNumber == i.asn
+}
+
+func (i *IPASN) Adapter() string {
+	return i.adapter
+}
+
+func (i *IPASN) Payload() string {
+	return strconv.FormatUint(uint64(i.asn), 10)
+}
+
+// NewIPASN accepts both 13335 and AS13335, the database is opened
+// like the one of NewGEOIP
+func NewIPASN(asn string, adapter string, env *Env) (*IPASN, error) {
+	number, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ASN %s", asn)
+	}
+
+	if env == nil {
+		env = &Env{}
+	}
+	if err := env.loadASN(); err != nil {
+		return nil, err
+	}
+
+	return &IPASN{
+		asn:     uint(number),
+		adapter: adapter,
+	}, nil
 }
@@ -0,0 +1,289 @@
+package rules
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	C "../constant"
+)
+
+// Domain types of v2ray geosite.dat
+const (
+	geositePlain = iota
+	geositeRegex
+	geositeDomain
+	geositeFull
+)
+
+// the loaded geosite.dat, it's read again when its path, size or mtime changes
+var (
+	geositePath    string
+	geositeSize    int64
+	geositeModTime time.Time
+	geositeEntries map[string][]byte
+
+	geositeCache = map[string]*domainMatcher{}
+	geositeLock  sync.Mutex
+)
+
+type GEOSITE struct {
+	category string
+	adapter  string
+	matcher  *domainMatcher
+}
+
+func (g *GEOSITE) RuleType() C.RuleType {
+	return C.GEOSITE
+}
+
+func (g *GEOSITE) IsMatch(addr *C.Addr) bool {
+	if addr.AddrType != C.AtypDomainName {
+		return false
+	}
+	return g.matcher.IsMatch(addr.Host)
+}
+
+func (g *GEOSITE) Adapter() string {
+	return g.adapter
+}
+
+func (g *GEOSITE) Payload() string {
+	return g.category
+}
+
+// NewGEOSITE accepts a category like cn, or category@attribute to pick the
+// domains carrying an attribute, e.g. geolocation-!cn@ads
+func NewGEOSITE(category string, adapter string) (*GEOSITE, error) {
+	geositeLock.Lock()
+	defer geositeLock.Unlock()
+
+	if err := reloadGeoSite(C.GeoSitePath); err != nil {
+		return nil, fmt.Errorf("can't load geosite %s: %s", C.GeoSitePath, err.Error())
+	}
+
+	category = strings.ToLower(category)
+
+	matcher, ok := geositeCache[category]
+	if !ok {
+		code, attr := category, ""
+		if idx := strings.Index(category, "@"); idx != -1 {
+			code, attr = category[:idx], category[idx+1:]
+		}
+
+		raw, exist := geositeEntries[code]
+		if !exist {
+			return nil, fmt.Errorf("geosite category %s not found", code)
+		}
+
+		var err error
+		matcher, err = parseGeoSite(raw, attr)
+		if err != nil {
+			return nil, fmt.Errorf("geosite category %s: %s", code, err.Error())
+		}
+		geositeCache[category] = matcher
+	}
+
+	return &GEOSITE{
+		category: category,
+		adapter:  adapter,
+		matcher:  matcher,
+	}, nil
+}
+
+// reloadGeoSite reads path when it differs from the loaded file,
+// failures keep nothing so the next rule tries again
+func reloadGeoSite(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if geositeEntries != nil && path == geositePath && info.Size() == geositeSize && info.ModTime().Equal(geositeModTime) {
+		return nil
+	}
+
+	entries, err := loadGeoSite(path)
+	if err != nil {
+		return err
+	}
+	geositePath, geositeSize, geositeModTime = path, info.Size(), info.ModTime()
+	geositeEntries = entries
+	geositeCache = map[string]*domainMatcher{}
+	return nil
+}
+
+type domainMatcher struct {
+	full     map[string]bool
+	suffix   *DomainTrie
+	keywords []string
+	regexps  []*regexp.Regexp
+}
+
+func (dm *domainMatcher) IsMatch(domain string) bool {
+	if dm.full[domain] {
+		return true
+	}
+
+	if _, ok := dm.suffix.Search(domain); ok {
+		return true
+	}
+
+	for _, keyword := range dm.keywords {
+		if strings.Contains(domain, keyword) {
+			return true
+		}
+	}
+
+	for _, re := range dm.regexps {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadGeoSite reads GeoSiteList and indexes the raw GeoSite messages by
+// their lower case country code, domains are decoded on demand
+func loadGeoSite(path string) (map[string][]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string][]byte{}
+	err = eachField(buf, func(num int, data []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		return eachField(data, func(num int, code []byte, _ uint64) error {
+			if num == 1 {
+				entries[strings.ToLower(string(code))] = data
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func parseGeoSite(buf []byte, attr string) (*domainMatcher, error) {
+	dm := &domainMatcher{
+		full:   map[string]bool{},
+		suffix: NewDomainTrie(),
+	}
+
+	err := eachField(buf, func(num int, data []byte, _ uint64) error {
+		if num != 2 {
+			return nil
+		}
+
+		var (
+			tp    uint64
+			value string
+			attrs []string
+		)
+		err := eachField(data, func(num int, data []byte, v uint64) error {
+			switch num {
+			case 1:
+				tp = v
+			case 2:
+				value = string(data)
+			case 3:
+				return eachField(data, func(num int, key []byte, _ uint64) error {
+					if num == 1 {
+						attrs = append(attrs, strings.ToLower(string(key)))
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if attr != "" && !contains(attrs, attr) {
+			return nil
+		}
+
+		switch tp {
+		case geositePlain:
+			dm.keywords = append(dm.keywords, value)
+		case geositeRegex:
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return err
+			}
+			dm.regexps = append(dm.regexps, re)
+		case geositeDomain:
+			dm.suffix.Insert(value, 0)
+		case geositeFull:
+			dm.full[value] = true
+		}
+		return nil
+	})
+	return dm, err
+}
+
+func contains(arr []string, s string) bool {
+	for _, elm := range arr {
+		if elm == s {
+			return true
+		}
+	}
+	return false
+}
+
+// eachField walks a protobuf message, data is set for length-delimited
+// fields and value for varint fields
+func eachField(buf []byte, fn func(num int, data []byte, value uint64) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errors.New("invalid protobuf key")
+		}
+		buf = buf[n:]
+
+		var (
+			data  []byte
+			value uint64
+		)
+		switch key & 7 {
+		case 0:
+			value, n = binary.Uvarint(buf)
+			if n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			buf = buf[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(buf) < size {
+				return errors.New("truncated protobuf field")
+			}
+			buf = buf[size:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < length {
+				return errors.New("truncated protobuf field")
+			}
+			data = buf[n : n+int(length)]
+			buf = buf[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+
+		if err := fn(int(key>>3), data, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package rules
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	C "../constant"
+)
+
+func field(num int, data []byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(num<<3|2))
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func domain(tp int, value string, attrs ...string) []byte {
+	buf := binary.AppendUvarint(nil, 1<<3)
+	buf = binary.AppendUvarint(buf, uint64(tp))
+	buf = append(buf, field(2, []byte(value))...)
+	for _, attr := range attrs {
+		buf = append(buf, field(3, field(1, []byte(attr)))...)
+	}
+	return buf
+}
+
+func TestGeoSite(t *testing.T) {
+	site := field(1, []byte("TEST"))
+	site = append(site, field(2, domain(geositeDomain, "google.com"))...)
+	site = append(site, field(2, domain(geositeFull, "www.apple.com"))...)
+	site = append(site, field(2, domain(geositePlain, "youtube"))...)
+	site = append(site, field(2, domain(geositeRegex, `^ad[0-9]+\.example\.org$`, "ads"))...)
+
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "GeoSite.dat")
+	ioutil.WriteFile(path, field(1, site), 0644)
+
+	entries, err := loadGeoSite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dm, err := parseGeoSite(entries["test"], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"google.com":        true,
+		"mail.google.com":   true,
+		"www.apple.com":     true,
+		"apple.com":         false,
+		"m.youtube.com":     true,
+		"ad12.example.org":  true,
+		"www.example.org":   false,
+		"notgoogle.com.cn":  false,
+		"ad12.example.org2": false,
+	}
+	for host, expected := range cases {
+		if dm.IsMatch(host) != expected {
+			t.Errorf("%s should match: %v", host, expected)
+		}
+	}
+
+	ads, _ := parseGeoSite(entries["test"], "ads")
+	if ads.IsMatch("google.com") || !ads.IsMatch("ad1.example.org") {
+		t.Error("attribute filter error")
+	}
+}
+
+func TestNewGEOSITE_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "GeoSite.dat")
+	defer func(old string) { C.GeoSitePath = old }(C.GeoSitePath)
+	C.GeoSitePath = path
+
+	// a missing file isn't remembered, it's loaded once it's added
+	if _, err := NewGEOSITE("test", "DIRECT"); err == nil {
+		t.Fatal("a missing geosite should fail")
+	}
+
+	site := append(field(1, []byte("TEST")), field(2, domain(geositeDomain, "google.com"))...)
+	ioutil.WriteFile(path, field(1, site), 0644)
+	rule, err := NewGEOSITE("test", "DIRECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.matcher.IsMatch("google.com") {
+		t.Fatal("google.com should match")
+	}
+
+	// a changed file replaces the categories
+	site = append(field(1, []byte("TEST")), field(2, domain(geositeDomain, "apple.com"))...)
+	ioutil.WriteFile(path, field(1, site), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	rule, err = NewGEOSITE("test", "DIRECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.matcher.IsMatch("google.com") || !rule.matcher.IsMatch("apple.com") {
+		t.Fatal("the changed geosite should be loaded")
+	}
+}
@@ -0,0 +1,129 @@
+package rules
+
+import (
+	C "../constant"
+)
+
+type segment interface {
+	Match(addr *C.Addr) C.Rule
+}
+
+type single struct {
+	rule C.Rule
+}
+
+func (s *single) Match(addr *C.Addr) C.Rule {
+	if s.rule.IsMatch(addr) {
+		return s.rule
+	}
+	return nil
+}
+
+type domainSuffixGroup struct {
+	rules []C.Rule
+	trie  *DomainTrie
+}
+
+func (g *domainSuffixGroup) Match(addr *C.Addr) C.Rule {
+	if addr.AddrType != C.AtypDomainName {
+		return nil
+	}
+	if idx, ok := g.trie.Search(addr.Host); ok {
+		return g.rules[idx]
+	}
+	return nil
+}
+
+type ipcidrGroup struct {
+	rules []C.Rule
+	trie  *IPCIDRTrie
+}
+
+func (g *ipcidrGroup) Match(addr *C.Addr) C.Rule {
+	if addr.IP == nil {
+		return nil
+	}
+	if idx, ok := g.trie.Search(*addr.IP); ok {
+		return g.rules[idx]
+	}
+	return nil
+}
+
+// Index compiles consecutive DOMAIN-SUFFIX and IP-CIDR rules into tries.
+// Each trie returns the earliest rule of its run, so the first matched
+// rule is the same as walking the rules one by one.
+type Index struct {
+	segments []segment
+}
+
+func (i *Index) Match(addr *C.Addr) C.Rule {
+	for _, s := range i.segments {
+		if rule := s.Match(addr); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+func NewIndex(rules []C.Rule) *Index {
+	index := &Index{}
+	for start := 0; start < len(rules); {
+		end := start + 1
+		switch rules[start].(type) {
+		case *DomainSuffix:
+			for end < len(rules) && isDomainSuffix(rules[end]) {
+				end++
+			}
+			if end-start > 1 {
+				index.segments = append(index.segments, newDomainSuffixGroup(rules[start:end]))
+				start = end
+				continue
+			}
+		case *IPCIDR:
+			if !isIndexableIPCIDR(rules[start]) {
+				break
+			}
+			for end < len(rules) && isIndexableIPCIDR(rules[end]) {
+				end++
+			}
+			if end-start > 1 {
+				index.segments = append(index.segments, newIPCIDRGroup(rules[start:end]))
+				start = end
+				continue
+			}
+		}
+		index.segments = append(index.segments, &single{rule: rules[start]})
+		start++
+	}
+	return index
+}
+
+func isDomainSuffix(rule C.Rule) bool {
+	_, ok := rule.(*DomainSuffix)
+	return ok
+}
+
+func isIndexableIPCIDR(rule C.Rule) bool {
+	i, ok := rule.(*IPCIDR)
+	if !ok || i.ipnet == nil {
+		return false
+	}
+	_, _, ok = normalize(i.ipnet)
+	return ok
+}
+
+func newDomainSuffixGroup(rules []C.Rule) *domainSuffixGroup {
+	trie := NewDomainTrie()
+	for idx, rule := range rules {
+		trie.Insert(rule.(*DomainSuffix).suffix, idx)
+	}
+	return &domainSuffixGroup{rules: rules, trie: trie}
+}
+
+func newIPCIDRGroup(rules []C.Rule) *ipcidrGroup {
+	trie := NewIPCIDRTrie()
+	for idx, rule := range rules {
+		trie.Insert(rule.(*IPCIDR).ipnet, idx)
+	}
+	return &ipcidrGroup{rules: rules, trie: trie}
+}
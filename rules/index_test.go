@@ -0,0 +1,133 @@
+package rules
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	C "../constant"
+)
+
+func linearMatch(rules []C.Rule, addr *C.Addr) C.Rule {
+	for _, rule := range rules {
+		if rule.IsMatch(addr) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func domainRules(n int) []C.Rule {
+	rules := []C.Rule{}
+	for i := 0; i < n; i++ {
+		rules = append(rules, NewDomainSuffix(fmt.Sprintf("site%d.com", i), fmt.Sprintf("P%d", i)))
+	}
+	return rules
+}
+
+func ipcidr(s string, adapter string) C.Rule {
+	rule, err := NewIPCIDR(s, adapter)
+	if err != nil {
+		panic(err)
+	}
+	return rule
+}
+
+func ipcidrRules(n int) []C.Rule {
+	r := rand.New(rand.NewSource(1))
+	rules := []C.Rule{}
+	for i := 0; i < n; i++ {
+		cidr := fmt.Sprintf("%d.%d.%d.0/%d", r.Intn(256), r.Intn(256), r.Intn(256), 8+r.Intn(17))
+		rules = append(rules, ipcidr(cidr, fmt.Sprintf("P%d", i)))
+	}
+	return rules
+}
+
+func TestIndex_FirstMatch(t *testing.T) {
+	rules := []C.Rule{
+		NewDomainSuffix("www.google.com", "A"),
+		NewDomainSuffix("google.com", "B"),
+		NewDomainKeyword("apple", "C"),
+		NewDomainSuffix("com", "D"),
+		NewDomainSuffix("apple.com", "E"),
+		ipcidr("10.0.0.0/8", "F"),
+		ipcidr("10.1.0.0/16", "G"),
+		ipcidr("2001:db8::/32", "H"),
+		NewFinal("I"),
+	}
+	rules = append(rules, domainRules(100)...)
+	rules = append(rules, ipcidrRules(100)...)
+	index := NewIndex(rules)
+
+	hosts := []string{"www.google.com", "mail.google.com", "google.com", "apple.com", "site1.com", "example.org", "oogle.com"}
+	for _, host := range hosts {
+		addr := &C.Addr{AddrType: C.AtypDomainName, Host: host}
+		if index.Match(addr) != linearMatch(rules, addr) {
+			t.Errorf("%s matched a different rule", host)
+		}
+	}
+
+	ipRules := append([]C.Rule{ipcidr("10.1.0.0/16", "J")}, ipcidrRules(1000)...)
+	ipIndex := NewIndex(ipRules)
+
+	r := rand.New(rand.NewSource(2))
+	ips := []string{"10.1.2.3", "10.2.3.4", "2001:db8::1", "::ffff:10.1.2.3"}
+	for i := 0; i < 1000; i++ {
+		ips = append(ips, fmt.Sprintf("%d.%d.%d.%d", r.Intn(256), r.Intn(256), r.Intn(256), r.Intn(256)))
+	}
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		addr := &C.Addr{AddrType: C.AtypIPv4, IP: &ip}
+		if index.Match(addr) != linearMatch(rules, addr) {
+			t.Errorf("%s matched a different rule", s)
+		}
+		if ipIndex.Match(addr) != linearMatch(ipRules, addr) {
+			t.Errorf("%s matched a different rule", s)
+		}
+	}
+}
+
+func benchmarkDomain(b *testing.B, match func(addr *C.Addr) C.Rule) {
+	addrs := []*C.Addr{}
+	for i := 0; i < 1024; i++ {
+		addrs = append(addrs, &C.Addr{AddrType: C.AtypDomainName, Host: fmt.Sprintf("www.site%d.com", i*48)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		match(addrs[i%len(addrs)])
+	}
+}
+
+func benchmarkIPCIDR(b *testing.B, match func(addr *C.Addr) C.Rule) {
+	r := rand.New(rand.NewSource(3))
+	addrs := []*C.Addr{}
+	for i := 0; i < 1024; i++ {
+		ip := net.IPv4(byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)))
+		addrs = append(addrs, &C.Addr{AddrType: C.AtypIPv4, IP: &ip})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		match(addrs[i%len(addrs)])
+	}
+}
+
+func BenchmarkLinear_DomainSuffix50k(b *testing.B) {
+	rules := domainRules(50000)
+	benchmarkDomain(b, func(addr *C.Addr) C.Rule { return linearMatch(rules, addr) })
+}
+
+func BenchmarkIndex_DomainSuffix50k(b *testing.B) {
+	index := NewIndex(domainRules(50000))
+	benchmarkDomain(b, index.Match)
+}
+
+func BenchmarkLinear_IPCIDR50k(b *testing.B) {
+	rules := ipcidrRules(50000)
+	benchmarkIPCIDR(b, func(addr *C.Addr) C.Rule { return linearMatch(rules, addr) })
+}
+
+func BenchmarkIndex_IPCIDR50k(b *testing.B) {
+	index := NewIndex(ipcidrRules(50000))
+	benchmarkIPCIDR(b, index.Match)
+}
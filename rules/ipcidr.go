@@ -1,6 +1,7 @@
 package rules
 
 import (
+	"fmt"
 	"net"
 
 	C "../constant"
@@ -31,12 +32,13 @@ func (i *IPCIDR) Payload() string {
 	return i.ipnet.String()
 }
 
-func NewIPCIDR(s string, adapter string) *IPCIDR {
+func NewIPCIDR(s string, adapter string) (*IPCIDR, error) {
 	_, ipnet, err := net.ParseCIDR(s)
 	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %s", s)
 	}
 	return &IPCIDR{
 		ipnet:   ipnet,
 		adapter: adapter,
-	}
+	}, nil
 }
@@ -0,0 +1,23 @@
+package rules
+
+import (
+	"net"
+	"testing"
+
+	C "../constant"
+)
+
+func TestNewIPCIDR(t *testing.T) {
+	if _, err := NewIPCIDR("10.0.0.0/33", "P"); err == nil {
+		t.Fatal("expected an error for an invalid CIDR")
+	}
+
+	rule, err := NewIPCIDR("10.0.0.0/8", "P")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("10.1.2.3")
+	if !rule.IsMatch(&C.Addr{IP: &ip}) {
+		t.Fatal("10.1.2.3 should match 10.0.0.0/8")
+	}
+}
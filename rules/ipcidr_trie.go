@@ -0,0 +1,90 @@
+package rules
+
+import (
+	"net"
+)
+
+// IPCIDRTrie is a binary prefix tree with separated IPv4 and IPv6 roots
+type IPCIDRTrie struct {
+	v4 *ipNode
+	v6 *ipNode
+}
+
+type ipNode struct {
+	children [2]*ipNode
+	value    int
+	marked   bool
+}
+
+// normalize converts ip and mask to the same family the way net.IPNet.Contains does
+func normalize(ipnet *net.IPNet) (net.IP, int, bool) {
+	mask := ipnet.Mask
+	ip := ipnet.IP.To4()
+	if ip == nil {
+		ip = ipnet.IP.To16()
+	} else if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+
+	ones, bits := mask.Size()
+	if ip == nil || bits != len(ip)*8 {
+		return nil, 0, false
+	}
+	return ip, ones, true
+}
+
+// Insert returns false if ipnet can't be indexed, e.g. with a non-canonical mask
+func (it *IPCIDRTrie) Insert(ipnet *net.IPNet, value int) bool {
+	ip, ones, ok := normalize(ipnet)
+	if !ok {
+		return false
+	}
+
+	node := it.v6
+	if len(ip) == net.IPv4len {
+		node = it.v4
+	}
+
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> uint(7-i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipNode{}
+		}
+		node = node.children[bit]
+	}
+
+	if !node.marked || value < node.value {
+		node.value = value
+		node.marked = true
+	}
+	return true
+}
+
+// Search returns the smallest value of all prefixes containing ip
+func (it *IPCIDRTrie) Search(ip net.IP) (int, bool) {
+	node := it.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, node = ip4, it.v4
+	} else if ip = ip.To16(); ip == nil {
+		return 0, false
+	}
+
+	value, found := 0, false
+	for i := 0; node != nil; i++ {
+		if node.marked && (!found || node.value < value) {
+			value, found = node.value, true
+		}
+		if i == len(ip)*8 {
+			break
+		}
+		node = node.children[ip[i/8]>>uint(7-i%8)&1]
+	}
+	return value, found
+}
+
+func NewIPCIDRTrie() *IPCIDRTrie {
+	return &IPCIDRTrie{
+		v4: &ipNode{},
+		v6: &ipNode{},
+	}
+}
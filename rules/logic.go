@@ -0,0 +1,148 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	C "../constant"
+)
+
+type Logic struct {
+	ruleType C.RuleType
+	payload  string
+	adapter  string
+	rules    []C.Rule
+}
+
+func (l *Logic) RuleType() C.RuleType {
+	return l.ruleType
+}
+
+func (l *Logic) IsMatch(addr *C.Addr) bool {
+	switch l.ruleType {
+	case C.AND:
+		for _, rule := range l.rules {
+			if !rule.IsMatch(addr) {
+				return false
+			}
+		}
+		return true
+	case C.OR:
+		for _, rule := range l.rules {
+			if rule.IsMatch(addr) {
+				return true
+			}
+		}
+		return false
+	default:
+		return !l.rules[0].IsMatch(addr)
+	}
+}
+
+func (l *Logic) Adapter() string {
+	return l.adapter
+}
+
+func (l *Logic) Payload() string {
+	return l.payload
+}
+
+// Rules returns the sub-rules of the logical rule
+func (l *Logic) Rules() []C.Rule {
+	return l.rules
+}
+
+// NewLogic parses a payload like ((DOMAIN-SUFFIX,google.com),(DST-PORT,443))
+func NewLogic(tp string, payload string, adapter string, env *Env) (*Logic, error) {
+	var ruleType C.RuleType
+	switch tp {
+	case "AND":
+		ruleType = C.AND
+	case "OR":
+		ruleType = C.OR
+	case "NOT":
+		ruleType = C.NOT
+	default:
+		return nil, fmt.Errorf("unsupported logic type %s", tp)
+	}
+
+	inner, err := unwrap(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := splitTopLevel(inner)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []C.Rule{}
+	for _, item := range items {
+		body, err := unwrap(item)
+		if err != nil {
+			return nil, err
+		}
+
+		subType, subPayload := body, ""
+		if idx := strings.Index(body, ","); idx != -1 {
+			subType, subPayload = body[:idx], body[idx+1:]
+		}
+
+		rule, err := ParseRule(strings.TrimSpace(subType), strings.TrimSpace(subPayload), "", env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", item, err.Error())
+		}
+		rules = append(rules, rule)
+	}
+
+	switch {
+	case len(rules) == 0:
+		return nil, fmt.Errorf("%s requires at least one sub-rule", tp)
+	case ruleType == C.NOT && len(rules) != 1:
+		return nil, errors.New("NOT requires exactly one sub-rule")
+	}
+
+	return &Logic{
+		ruleType: ruleType,
+		payload:  payload,
+		adapter:  adapter,
+		rules:    rules,
+	}, nil
+}
+
+func unwrap(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return "", fmt.Errorf("%s should be wrapped in parentheses", s)
+	}
+	return s[1 : len(s)-1], nil
+}
+
+func splitTopLevel(s string) ([]string, error) {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %s", s)
+			}
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in %s", s)
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		items = append(items, s[start:])
+	}
+	return items, nil
+}
@@ -0,0 +1,56 @@
+package rules
+
+import (
+	"net"
+	"testing"
+
+	C "../constant"
+)
+
+func TestLogic(t *testing.T) {
+	and, err := NewLogic("AND", "((DOMAIN-SUFFIX,google.com),(DST-PORT,443))", "Proxy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !and.IsMatch(&C.Addr{AddrType: C.AtypDomainName, Host: "www.google.com", Port: "443"}) {
+		t.Error("AND should match")
+	}
+
+	if and.IsMatch(&C.Addr{AddrType: C.AtypDomainName, Host: "www.google.com", Port: "80"}) {
+		t.Error("AND should not match")
+	}
+
+	ip := net.ParseIP("10.0.0.1")
+	or, err := NewLogic("OR", "((NOT,((IP-CIDR,10.0.0.0/8))),(DOMAIN-KEYWORD,google))", "Proxy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if or.IsMatch(&C.Addr{AddrType: C.AtypIPv4, IP: &ip, Port: "80"}) {
+		t.Error("OR should not match")
+	}
+
+	if len(or.Rules()) != 2 || or.Rules()[0].RuleType() != C.NOT {
+		t.Error("OR sub-rules error")
+	}
+}
+
+func TestLogic_Invalid(t *testing.T) {
+	payloads := []string{
+		"(DOMAIN-SUFFIX,google.com)",
+		"((DOMAIN-SUFFIX,google.com)",
+		"((UNKNOWN,google.com))",
+		"()",
+	}
+
+	for _, payload := range payloads {
+		if _, err := NewLogic("AND", payload, "Proxy", nil); err == nil {
+			t.Errorf("%s should be invalid", payload)
+		}
+	}
+
+	if _, err := NewLogic("NOT", "((DST-PORT,80),(DST-PORT,443))", "Proxy", nil); err == nil {
+		t.Error("NOT with two sub-rules should be invalid")
+	}
+}
@@ -0,0 +1,285 @@
+package rules
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+
+	C "../constant"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const maxMindURL = "https://download.maxmind.com/app/geoip_download?edition_id=GeoLite2-Country&license_key=%s&suffix=%s"
+
+// maxDownload bounds the size of a downloaded database
+const maxDownload = 256 << 20
+
+// geoipClient allows for databases being much larger than rule sets
+var geoipClient = &http.Client{Timeout: 5 * time.Minute}
+
+// GeoIPSource tells where the Country database of GEOIP rules comes from
+type GeoIPSource struct {
+	// Path is the database file, downloads are written to it
+	Path string
+	// URL serves an mmdb or a tar.gz containing one
+	URL string
+	// LicenseKey downloads GeoLite2-Country from MaxMind when URL is empty
+	LicenseKey string
+	// SHA256 is the expected digest of the download, when it's empty
+	// downloads are checked against the digest published next to them
+	SHA256 string
+	// Interval between updates, 0 disables them, so does a pinned SHA256
+	// since a newer database can't match it
+	Interval time.Duration
+}
+
+func (s GeoIPSource) path() string {
+	if s.Path == "" {
+		return C.MMDBPath
+	}
+	return s.Path
+}
+
+func (s GeoIPSource) downloadable() bool {
+	return s.URL != "" || s.LicenseKey != ""
+}
+
+func (s GeoIPSource) url(suffix string) string {
+	if s.URL != "" {
+		return s.URL
+	}
+	return fmt.Sprintf(maxMindURL, url.QueryEscape(s.LicenseKey), suffix)
+}
+
+// digestURL is where the SHA-256 of the download is published,
+// <url>.sha256 for URL sources
+func (s GeoIPSource) digestURL() string {
+	if s.URL == "" {
+		return s.url("tar.gz.sha256")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return s.URL + ".sha256"
+	}
+	u.Path += ".sha256"
+	return u.String()
+}
+
+// redact keeps the license key out of errors
+func (s GeoIPSource) redact(err error) error {
+	if s.LicenseKey == "" {
+		return err
+	}
+	return errors.New(strings.Replace(err.Error(), url.QueryEscape(s.LicenseKey), "***", -1))
+}
+
+var (
+	mmdb       *geoip2.Reader
+	mmdbPath   string
+	mmdbSource GeoIPSource
+	mmdbDone   chan struct{}
+	// mmdbEnv is the env committed last
+	mmdbEnv  *Env
+	mmdbLock sync.RWMutex
+
+	// one download at a time
+	updateLock sync.Mutex
+)
+
+// SetGeoIP changes the source of the Country database and its updates,
+// the database itself is opened by the Env of the config
+func SetGeoIP(source GeoIPSource) {
+	mmdbLock.Lock()
+	defer mmdbLock.Unlock()
+	if source == mmdbSource {
+		return
+	}
+	mmdbSource = source
+
+	if mmdbDone != nil {
+		close(mmdbDone)
+		mmdbDone = nil
+	}
+	if source.Interval > 0 && source.SHA256 != "" {
+		log.Warnln("MMDB updates are disabled by geoip-sha256")
+	} else if source.Interval > 0 && source.downloadable() {
+		mmdbDone = make(chan struct{})
+		go geoipLoop(source, mmdbDone)
+	}
+}
+
+func currentMMDB() *geoip2.Reader {
+	mmdbLock.RLock()
+	defer mmdbLock.RUnlock()
+	return mmdb
+}
+
+func swapMMDB(db *geoip2.Reader, path string) {
+	mmdbLock.Lock()
+	defer mmdbLock.Unlock()
+
+	// readers are loaded into memory, lookups still running on the old one are safe
+	mmdb, mmdbPath = db, path
+}
+
+// readMMDB loads a database into memory, so closing it never breaks a lookup
+func readMMDB(path string) (*geoip2.Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return geoip2.FromBytes(buf)
+}
+
+// UpdateGeoIP downloads the database of the current source, verifies it,
+// writes it atomically and swaps it in for all GEOIP rules
+func UpdateGeoIP() error {
+	mmdbLock.RLock()
+	source := mmdbSource
+	mmdbLock.RUnlock()
+
+	db, err := fetchGeoIP(source)
+	if err != nil {
+		return err
+	}
+
+	mmdbLock.Lock()
+	defer mmdbLock.Unlock()
+	// a config applied meanwhile brings its own database
+	if source == mmdbSource {
+		mmdb, mmdbPath = db, source.path()
+	}
+	return nil
+}
+
+// fetchGeoIP downloads the database of source, verifies it and writes it atomically
+func fetchGeoIP(source GeoIPSource) (*geoip2.Reader, error) {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+
+	if !source.downloadable() {
+		return nil, errors.New("geoip-url or geoip-license-key is required to download the MMDB")
+	}
+
+	buf, err := download(source.url("tar.gz"))
+	if err != nil {
+		return nil, source.redact(err)
+	}
+
+	digest := source.SHA256
+	if digest == "" {
+		sum, err := download(source.digestURL())
+		switch {
+		case err == nil:
+			if fields := strings.Fields(string(sum)); len(fields) > 0 {
+				digest = fields[0]
+			}
+		case source.URL == "":
+			// MaxMind always publishes it
+			return nil, source.redact(err)
+		default:
+			log.Warnf("MMDB download is not verified, set geoip-sha256 or publish %s: %s", source.digestURL(), err.Error())
+		}
+	}
+	if digest != "" {
+		sum := sha256.Sum256(buf)
+		if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, digest) {
+			return nil, fmt.Errorf("MMDB checksum mismatch, expect %s, got %s", digest, actual)
+		}
+	}
+
+	if bytes.HasPrefix(buf, []byte{0x1f, 0x8b}) {
+		if buf, err = extractMMDB(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	// reject broken databases before they replace the file
+	db, err := geoip2.FromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MMDB: %s", err.Error())
+	}
+
+	path := source.path()
+	if err := writeFileAtomic(path, buf); err != nil {
+		return nil, err
+	}
+	log.Infof("MMDB %s updated", path)
+	return db, nil
+}
+
+func download(rawURL string) ([]byte, error) {
+	resp, err := geoipClient.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s response %s", rawURL, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxDownload))
+}
+
+// extractMMDB returns the first .mmdb file of a tar.gz
+func extractMMDB(buf []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("no .mmdb file in the archive")
+		} else if err != nil {
+			return nil, err
+		}
+
+		if strings.HasSuffix(h.Name, ".mmdb") {
+			return ioutil.ReadAll(io.LimitReader(tr, maxDownload))
+		}
+	}
+}
+
+// geoipLoop updates the database every interval until done is closed,
+// a database older than interval is updated right away
+func geoipLoop(source GeoIPSource, done chan struct{}) {
+	update := func() {
+		if err := UpdateGeoIP(); err != nil {
+			log.Warnf("MMDB update error: %s", err.Error())
+		}
+	}
+
+	if info, err := os.Stat(source.path()); err == nil && time.Since(info.ModTime()) > source.Interval {
+		update()
+	}
+
+	tick := time.NewTicker(source.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			update()
+		case <-done:
+			return
+		}
+	}
+}
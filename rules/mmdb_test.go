@@ -0,0 +1,207 @@
+package rules
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	C "../constant"
+)
+
+// testMMDB is an empty GeoLite2-Country database, just the metadata
+func testMMDB() []byte {
+	str := func(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+	u16 := func(v uint16) []byte { return []byte{0xa2, byte(v >> 8), byte(v)} }
+	u32 := func(v uint32) []byte {
+		b := []byte{0xc4, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], v)
+		return b
+	}
+	u64 := func(v uint64) []byte {
+		b := []byte{0x08, 0x02, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[2:], v)
+		return b
+	}
+
+	// empty search tree, data section separator, metadata marker and a map of 9 entries
+	buf := make([]byte, 16)
+	buf = append(buf, "\xab\xcd\xefMaxMind.com"...)
+	buf = append(buf, 0xe0|9)
+	for _, kv := range [][2][]byte{
+		{str("binary_format_major_version"), u16(2)},
+		{str("binary_format_minor_version"), u16(0)},
+		{str("build_epoch"), u64(1)},
+		{str("database_type"), str("GeoLite2-Country")},
+		{str("description"), {0xe0}},
+		{str("ip_version"), u16(6)},
+		{str("languages"), {0x00, 0x04}},
+		{str("node_count"), u32(0)},
+		{str("record_size"), u16(24)},
+	} {
+		buf = append(buf, kv[0]...)
+		buf = append(buf, kv[1]...)
+	}
+	return buf
+}
+
+func tarGz(name string, content []byte) []byte {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestUpdateGeoIP(t *testing.T) {
+	archive := tarGz("GeoLite2-Country_20200101/GeoLite2-Country.mmdb", testMMDB())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "mmdb")
+	defer os.RemoveAll(dir)
+	defer SetGeoIP(GeoIPSource{})
+	path := filepath.Join(dir, "Country.mmdb")
+
+	SetGeoIP(GeoIPSource{Path: path, URL: server.URL, SHA256: strings.Repeat("0", 64)})
+	if err := UpdateGeoIP(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("a rejected download should not be written")
+	}
+
+	sum := sha256.Sum256(archive)
+	SetGeoIP(GeoIPSource{Path: path, URL: server.URL, SHA256: hex.EncodeToString(sum[:])})
+	if err := UpdateGeoIP(); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(path); !bytes.Equal(buf, testMMDB()) {
+		t.Fatal("the mmdb should be extracted from the archive")
+	}
+
+	rule, err := NewGEOIP("CN", "DIRECT", &Env{GeoIP: GeoIPSource{Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("1.2.3.4")
+	if rule.IsMatch(&C.Addr{IP: &ip}) {
+		t.Fatal("an empty database matches nothing")
+	}
+}
+
+func TestUpdateGeoIP_Digest(t *testing.T) {
+	archive := tarGz("GeoLite2-Country.mmdb", testMMDB())
+	digest := strings.Repeat("0", 64)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Country.tar.gz":
+			w.Write(archive)
+		case "/Country.tar.gz.sha256":
+			w.Write([]byte(digest + "  Country.tar.gz\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "mmdb")
+	defer os.RemoveAll(dir)
+	defer SetGeoIP(GeoIPSource{})
+	path := filepath.Join(dir, "Country.mmdb")
+
+	// the digest published next to the download is checked
+	SetGeoIP(GeoIPSource{Path: path, URL: server.URL + "/Country.tar.gz?v=1"})
+	if err := UpdateGeoIP(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+
+	sum := sha256.Sum256(archive)
+	digest = hex.EncodeToString(sum[:])
+	if err := UpdateGeoIP(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGEOIPWithoutSource(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mmdb")
+	defer os.RemoveAll(dir)
+
+	env := &Env{GeoIP: GeoIPSource{Path: filepath.Join(dir, "Country.mmdb")}}
+	if _, err := NewGEOIP("CN", "DIRECT", env); err == nil || !strings.Contains(err.Error(), "geoip-url") {
+		t.Fatalf("expected a missing database error, got %v", err)
+	}
+}
+
+func TestEnv_Commit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mmdb")
+	defer os.RemoveAll(dir)
+	defer swapMMDB(nil, "")
+	defer SetGeoIP(GeoIPSource{})
+	path := filepath.Join(dir, "Country.mmdb")
+	ioutil.WriteFile(path, testMMDB(), 0644)
+
+	running := currentMMDB()
+	env := &Env{GeoIP: GeoIPSource{Path: path}}
+	if _, err := NewGEOIP("CN", "DIRECT", env); err != nil {
+		t.Fatal(err)
+	}
+	if currentMMDB() != running || mmdbSource == env.GeoIP {
+		t.Fatal("the running database should be kept until commit")
+	}
+
+	env.Commit()
+	if currentMMDB() != env.mmdb || mmdbSource != env.GeoIP {
+		t.Fatal("commit should swap in the database of env")
+	}
+}
+
+func TestEnv_CommitClosesASN(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mmdb")
+	defer os.RemoveAll(dir)
+	defer SetGeoIP(GeoIPSource{})
+	path := filepath.Join(dir, "ASN.mmdb")
+	ioutil.WriteFile(path, testMMDB(), 0644)
+
+	first := &Env{ASNPath: path}
+	if _, err := NewIPASN("13335", "DIRECT", first); err != nil {
+		t.Fatal(err)
+	}
+	first.Commit()
+	if _, err := first.asn.Country(netip.MustParseAddr("1.2.3.4")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same path keeps the reader
+	second := &Env{ASNPath: path}
+	NewIPASN("13335", "DIRECT", second)
+	if second.asn != first.asn {
+		t.Fatal("the running reader should be reused")
+	}
+	second.Commit()
+
+	(&Env{ASNPath: filepath.Join(dir, "other.mmdb")}).Commit()
+	// the test database is a Country one
+	if _, err := first.asn.Country(netip.MustParseAddr("1.2.3.4")); err == nil {
+		t.Fatal("the replaced reader should be closed")
+	}
+	if currentASN() != nil {
+		t.Fatal("a config without IP-ASN rules drops the reader")
+	}
+}
@@ -0,0 +1,38 @@
+package rules
+
+import (
+	"fmt"
+
+	C "../constant"
+)
+
+// ParseRule builds a rule from its type, payload and adapter name,
+// a nil env has no providers and only shares the databases of the running rules
+func ParseRule(tp string, payload string, adapter string, env *Env) (C.Rule, error) {
+	switch tp {
+	case "DOMAIN-SUFFIX":
+		return NewDomainSuffix(payload, adapter), nil
+	case "DOMAIN-KEYWORD":
+		return NewDomainKeyword(payload, adapter), nil
+	case "GEOIP":
+		return NewGEOIP(payload, adapter, env)
+	case "IP-ASN":
+		return NewIPASN(payload, adapter, env)
+	case "GEOSITE":
+		return NewGEOSITE(payload, adapter)
+	case "IP-CIDR", "IP-CIDR6":
+		return NewIPCIDR(payload, adapter)
+	case "DST-PORT":
+		return NewPort(payload, adapter)
+	case "USER":
+		return NewUser(payload, adapter), nil
+	case "AND", "OR", "NOT":
+		return NewLogic(tp, payload, adapter, env)
+	case "RULE-SET":
+		return NewRuleSet(payload, adapter, env.providers())
+	case "FINAL":
+		return NewFinal(adapter), nil
+	default:
+		return nil, fmt.Errorf("unsupported rule type %s", tp)
+	}
+}
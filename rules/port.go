@@ -0,0 +1,40 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+
+	C "../constant"
+)
+
+type Port struct {
+	port    string
+	adapter string
+}
+
+func (p *Port) RuleType() C.RuleType {
+	return C.DstPort
+}
+
+func (p *Port) IsMatch(addr *C.Addr) bool {
+	return addr.Port == p.port
+}
+
+func (p *Port) Adapter() string {
+	return p.adapter
+}
+
+func (p *Port) Payload() string {
+	return p.port
+}
+
+func NewPort(port string, adapter string) (*Port, error) {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %s", port)
+	}
+	return &Port{
+		port:    strconv.Itoa(n),
+		adapter: adapter,
+	}, nil
+}
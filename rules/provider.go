@@ -0,0 +1,443 @@
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	C "../constant"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Rule provider behaviors
+const (
+	BehaviorDomain    = "domain"
+	BehaviorIPCIDR    = "ipcidr"
+	BehaviorClassical = "classical"
+)
+
+// client fetches remote rule sets, a hung server can't block config loads or updates
+var client = &http.Client{Timeout: 30 * time.Second}
+
+type matcher interface {
+	IsMatch(addr *C.Addr) bool
+	Count() int
+}
+
+type RuleProvider struct {
+	name      string
+	behavior  string
+	source    string
+	remote    bool
+	cachePath string
+	interval  time.Duration
+	// env opens the databases of classical rules
+	env *Env
+
+	matcher   matcher
+	updatedAt time.Time
+	lock      sync.RWMutex
+	done      chan struct{}
+}
+
+func (rp *RuleProvider) Name() string {
+	return rp.name
+}
+
+func (rp *RuleProvider) Behavior() string {
+	return rp.behavior
+}
+
+func (rp *RuleProvider) Source() string {
+	return rp.source
+}
+
+func (rp *RuleProvider) UpdatedAt() time.Time {
+	rp.lock.RLock()
+	defer rp.lock.RUnlock()
+	return rp.updatedAt
+}
+
+func (rp *RuleProvider) Count() int {
+	rp.lock.RLock()
+	defer rp.lock.RUnlock()
+	return rp.matcher.Count()
+}
+
+func (rp *RuleProvider) IsMatch(addr *C.Addr) bool {
+	rp.lock.RLock()
+	m := rp.matcher
+	rp.lock.RUnlock()
+	return m.IsMatch(addr)
+}
+
+// Update fetches the source again and swaps the rules in one step,
+// the old rules keep serving if anything goes wrong
+func (rp *RuleProvider) Update() error {
+	buf, err := rp.fetch()
+	if err != nil {
+		return err
+	}
+	return rp.load(buf)
+}
+
+func (rp *RuleProvider) Close() {
+	if rp.done != nil {
+		close(rp.done)
+	}
+}
+
+func (rp *RuleProvider) load(buf []byte) error {
+	m, err := parseProvider(rp.behavior, buf, rp.env)
+	if err != nil {
+		return fmt.Errorf("rule provider %s: %s", rp.name, err.Error())
+	}
+
+	rp.lock.Lock()
+	rp.matcher = m
+	rp.updatedAt = time.Now()
+	rp.lock.Unlock()
+	return nil
+}
+
+func (rp *RuleProvider) fetch() ([]byte, error) {
+	if !rp.remote {
+		return ioutil.ReadFile(rp.source)
+	}
+
+	resp, err := client.Get(rp.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s response %s", rp.source, resp.Status)
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// reject broken content before it reaches the cache
+	if _, err := parseProvider(rp.behavior, buf, rp.env); err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(rp.cachePath, buf); err != nil {
+		log.Warnf("Rule provider %s cache error: %s", rp.name, err.Error())
+	}
+	return buf, nil
+}
+
+func (rp *RuleProvider) loop() {
+	tick := time.NewTicker(rp.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := rp.Update(); err != nil {
+				log.Warnf("Rule provider %s update error: %s", rp.name, err.Error())
+				continue
+			}
+			log.Infof("Rule provider %s updated, %d rules", rp.name, rp.Count())
+		case <-rp.done:
+			return
+		}
+	}
+}
+
+func newRuleProvider(name string, behavior string, source string, cachePath string, interval time.Duration, env *Env) (*RuleProvider, error) {
+	switch behavior {
+	case BehaviorDomain, BehaviorIPCIDR, BehaviorClassical:
+	default:
+		return nil, fmt.Errorf("rule provider %s: unsupported behavior %s", name, behavior)
+	}
+
+	return &RuleProvider{
+		name:      name,
+		behavior:  behavior,
+		source:    source,
+		remote:    strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"),
+		cachePath: cachePath,
+		interval:  interval,
+		env:       env,
+	}, nil
+}
+
+// CheckRuleProvider validates a provider without touching the network or
+// the cache, local files are parsed with env and remote sources match nothing
+func CheckRuleProvider(name string, behavior string, source string, env *Env) (*RuleProvider, error) {
+	rp, err := newRuleProvider(name, behavior, source, "", 0, env)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if !rp.remote {
+		if buf, err = rp.fetch(); err != nil {
+			return nil, fmt.Errorf("rule provider %s: %s", name, err.Error())
+		}
+	}
+	if err := rp.load(buf); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// NewRuleProvider creates a provider from a local file or an http(s) url.
+// Remote sources are cached at cachePath, a fresh cache is used on start,
+// an expired one only when the source can't be fetched, and the source is
+// fetched again every interval. Classical rules open their
+// databases through env, the one of the config the provider belongs to.
+func NewRuleProvider(name string, behavior string, source string, cachePath string, interval time.Duration, env *Env) (*RuleProvider, error) {
+	rp, err := newRuleProvider(name, behavior, source, cachePath, interval, env)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if rp.remote {
+		var fresh bool
+		buf, fresh, err = rp.readCache()
+		if !fresh {
+			fetched, fetchErr := rp.fetch()
+			switch {
+			case fetchErr == nil:
+				buf = fetched
+			case err == nil:
+				// an outdated rule set is better than none
+				log.Warnf("Rule provider %s fetch error, using the expired cache: %s", name, fetchErr.Error())
+			default:
+				return nil, fmt.Errorf("rule provider %s: %s", name, fetchErr.Error())
+			}
+		}
+	} else {
+		buf, err = rp.fetch()
+		if err != nil {
+			return nil, fmt.Errorf("rule provider %s: %s", name, err.Error())
+		}
+	}
+
+	if err := rp.load(buf); err != nil {
+		return nil, err
+	}
+
+	if rp.remote && interval > 0 {
+		rp.done = make(chan struct{})
+		go rp.loop()
+	}
+	return rp, nil
+}
+
+// readCache returns the cached content and whether it's still within the interval
+func (rp *RuleProvider) readCache() ([]byte, bool, error) {
+	info, err := os.Stat(rp.cachePath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	buf, err := ioutil.ReadFile(rp.cachePath)
+	if err != nil {
+		return nil, false, err
+	}
+	return buf, rp.interval == 0 || time.Since(info.ModTime()) <= rp.interval, nil
+}
+
+func writeFileAtomic(path string, buf []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// parseProvider accepts one entry per line, comments start with "#".
+// The "payload:" list used by yaml rule sets is accepted as well.
+func parseProvider(behavior string, buf []byte, env *Env) (matcher, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "payload:" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") {
+			line = strings.Trim(strings.TrimSpace(line[2:]), `'"`)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	switch behavior {
+	case BehaviorDomain:
+		return newDomainSet(lines), nil
+	case BehaviorIPCIDR:
+		return newIPCIDRSet(lines)
+	default:
+		return newClassicalSet(lines, env)
+	}
+}
+
+// domainSet follows the usual domain list syntax:
+// "example.com" matches the domain itself, ".example.com" matches
+// its subdomains and "+.example.com" matches both
+type domainSet struct {
+	full   map[string]bool
+	suffix map[string]bool
+	sub    map[string]bool
+	count  int
+}
+
+func (ds *domainSet) IsMatch(addr *C.Addr) bool {
+	if addr.AddrType != C.AtypDomainName {
+		return false
+	}
+
+	domain := addr.Host
+	if ds.full[domain] || ds.suffix[domain] {
+		return true
+	}
+
+	for i := 0; i < len(domain); i++ {
+		if domain[i] != '.' {
+			continue
+		}
+		parent := domain[i+1:]
+		if ds.suffix[parent] || ds.sub[parent] {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds *domainSet) Count() int {
+	return ds.count
+}
+
+func newDomainSet(lines []string) *domainSet {
+	ds := &domainSet{
+		full:   map[string]bool{},
+		suffix: map[string]bool{},
+		sub:    map[string]bool{},
+	}
+
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+."):
+			ds.suffix[line[2:]] = true
+		case strings.HasPrefix(line, "."):
+			ds.sub[line[1:]] = true
+		default:
+			ds.full[line] = true
+		}
+		ds.count++
+	}
+	return ds
+}
+
+type ipcidrSet struct {
+	ipnets []*net.IPNet
+}
+
+func (is *ipcidrSet) IsMatch(addr *C.Addr) bool {
+	if addr.IP == nil {
+		return false
+	}
+
+	for _, ipnet := range is.ipnets {
+		if ipnet.Contains(*addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (is *ipcidrSet) Count() int {
+	return len(is.ipnets)
+}
+
+func newIPCIDRSet(lines []string) (*ipcidrSet, error) {
+	is := &ipcidrSet{}
+	for _, line := range lines {
+		_, ipnet, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, err
+		}
+		is.ipnets = append(is.ipnets, ipnet)
+	}
+	return is, nil
+}
+
+type classicalSet struct {
+	rules []C.Rule
+}
+
+func (cs *classicalSet) IsMatch(addr *C.Addr) bool {
+	for _, rule := range cs.rules {
+		if rule.IsMatch(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *classicalSet) Count() int {
+	return len(cs.rules)
+}
+
+func newClassicalSet(lines []string, env *Env) (*classicalSet, error) {
+	cs := &classicalSet{}
+	env = env.ruleSet()
+	for _, line := range lines {
+		rule := strings.SplitN(line, ",", 2)
+		if len(rule) != 2 {
+			return nil, fmt.Errorf("%s: missing payload", line)
+		}
+
+		tp, payload := strings.TrimSpace(rule[0]), strings.TrimSpace(rule[1])
+		switch tp {
+		case "RULE-SET", "FINAL":
+			return nil, fmt.Errorf("%s is not allowed in a rule set", tp)
+		case "AND", "OR", "NOT":
+		default:
+			// drop trailing options such as no-resolve
+			payload = strings.TrimSpace(strings.Split(payload, ",")[0])
+		}
+
+		parsed, err := ParseRule(tp, payload, "", env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", line, err.Error())
+		}
+		cs.rules = append(cs.rules, parsed)
+	}
+	return cs, nil
+}
@@ -0,0 +1,264 @@
+package rules
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	C "../constant"
+)
+
+func TestRuleProvider_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "domain.txt")
+	ioutil.WriteFile(path, []byte("# comment\nexample.com\n+.google.com\n.apple.com\n"), 0644)
+
+	rp, err := NewRuleProvider("domain", BehaviorDomain, path, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"example.com":     true,
+		"www.example.com": false,
+		"google.com":      true,
+		"www.google.com":  true,
+		"apple.com":       false,
+		"www.apple.com":   true,
+	}
+	for host, expected := range cases {
+		if rp.IsMatch(&C.Addr{AddrType: C.AtypDomainName, Host: host}) != expected {
+			t.Errorf("%s should match: %v", host, expected)
+		}
+	}
+
+	// a failed update keeps the old rules
+	os.Remove(path)
+	if err := rp.Update(); err == nil {
+		t.Error("update should fail")
+	}
+	if rp.Count() != 3 {
+		t.Error("rules should be kept after a failed update")
+	}
+}
+
+func TestRuleProvider_Remote(t *testing.T) {
+	payload := "10.0.0.0/8\n192.168.0.0/16\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "ruleset", "lan")
+
+	rp, err := NewRuleProvider("lan", BehaviorIPCIDR, server.URL, cachePath, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Count() != 2 {
+		t.Fatalf("expect 2 rules, got %d", rp.Count())
+	}
+
+	// a broken payload is rejected before it reaches the rules or the cache
+	payload = "not a cidr\n"
+	if err := rp.Update(); err == nil {
+		t.Error("update should fail")
+	}
+	if rp.Count() != 2 {
+		t.Error("rules should be kept after a failed update")
+	}
+	if buf, _ := ioutil.ReadFile(cachePath); string(buf) != "10.0.0.0/8\n192.168.0.0/16\n" {
+		t.Errorf("the cache should be kept, got %q", buf)
+	}
+}
+
+func TestRuleProvider_ExpiredCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "ruleset", "lan")
+
+	if _, err := NewRuleProvider("lan", BehaviorIPCIDR, server.URL, cachePath, time.Hour, nil); err == nil {
+		t.Fatal("expected an error without a cache")
+	}
+
+	writeFileAtomic(cachePath, []byte("10.0.0.0/8\n"))
+	expired := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(cachePath, expired, expired)
+	rp, err := NewRuleProvider("lan", BehaviorIPCIDR, server.URL, cachePath, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rp.Close()
+	if rp.Count() != 1 {
+		t.Fatal("the expired cache should be used when the fetch fails")
+	}
+}
+
+func TestCheckRuleProvider(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	rp, err := CheckRuleProvider("lan", BehaviorIPCIDR, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested || rp.Count() != 0 {
+		t.Fatal("a remote source should not be fetched")
+	}
+
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// local files are still validated
+	path := filepath.Join(dir, "lan.txt")
+	ioutil.WriteFile(path, []byte("not a cidr\n"), 0644)
+	if _, err := CheckRuleProvider("lan", BehaviorIPCIDR, path, nil); err == nil {
+		t.Fatal("expected an invalid rule set")
+	}
+
+	// GEOIP is checked against the database of the config, which isn't downloaded
+	path = filepath.Join(dir, "cn.txt")
+	ioutil.WriteFile(path, []byte("GEOIP,CN\n"), 0644)
+	mmdbPath := filepath.Join(dir, "Country.mmdb")
+	env := &Env{Check: true, GeoIP: GeoIPSource{Path: mmdbPath, URL: server.URL}}
+	if _, err := CheckRuleProvider("cn", BehaviorClassical, path, env); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(mmdbPath); requested || !os.IsNotExist(err) {
+		t.Fatal("the database should not be downloaded")
+	}
+	env = &Env{Check: true, GeoIP: GeoIPSource{Path: mmdbPath}}
+	if _, err := CheckRuleProvider("cn", BehaviorClassical, path, env); err == nil || !strings.Contains(err.Error(), mmdbPath) {
+		t.Fatalf("expected a missing database error, got %v", err)
+	}
+}
+
+func TestRuleProvider_Classical(t *testing.T) {
+	m, err := parseProvider(BehaviorClassical, []byte("payload:\n  - 'IP-CIDR,10.0.0.0/8,no-resolve'\n  - DST-PORT,22\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("10.1.1.1")
+	if !m.IsMatch(&C.Addr{AddrType: C.AtypIPv4, IP: &ip, Port: "443"}) {
+		t.Error("IP-CIDR should match")
+	}
+
+	if m.Count() != 2 {
+		t.Error("classical rules count error")
+	}
+}
+
+func TestRuleProvider_ClassicalASN(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer (&Env{}).Commit()
+
+	asnPath := filepath.Join(dir, "ASN.mmdb")
+	ioutil.WriteFile(asnPath, testMMDB(), 0644)
+	path := filepath.Join(dir, "asn.txt")
+	ioutil.WriteFile(path, []byte("IP-ASN,13335\n"), 0644)
+
+	// no IP-ASN rule outside of the rule set
+	env := &Env{ASNPath: asnPath}
+	if _, err := NewRuleProvider("asn", BehaviorClassical, path, "", 0, env); err != nil {
+		t.Fatal(err)
+	}
+	if env.asn == nil {
+		t.Fatal("the rule set should open the database of env")
+	}
+
+	env.Commit()
+	if currentASN() != env.asn {
+		t.Fatal("commit should hand the database of the rule set to the running rules")
+	}
+
+	// the first IP-ASN rule may come with an update
+	(&Env{}).Commit()
+	ioutil.WriteFile(path, []byte("DST-PORT,22\n"), 0644)
+	env = &Env{ASNPath: asnPath}
+	rp, err := NewRuleProvider("asn", BehaviorClassical, path, "", 0, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Commit()
+	ioutil.WriteFile(path, []byte("IP-ASN,13335\n"), 0644)
+	if err := rp.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if currentASN() == nil || currentASN() != env.asn {
+		t.Fatal("a database opened after commit should be used by the running rules")
+	}
+}
+
+func TestRuleProvider_ClassicalGEOIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(testMMDB())
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer swapMMDB(nil, "")
+	defer SetGeoIP(GeoIPSource{})
+
+	path := filepath.Join(dir, "geoip.txt")
+	ioutil.WriteFile(path, []byte("GEOIP,CN\n"), 0644)
+
+	running := currentMMDB()
+	env := &Env{GeoIP: GeoIPSource{Path: filepath.Join(dir, "Country.mmdb"), URL: server.URL}}
+	if _, err := NewRuleProvider("cn", BehaviorClassical, path, "", 0, env); err != nil {
+		t.Fatal(err)
+	}
+	if env.mmdb == nil {
+		t.Fatal("the rule set should download the database of env")
+	}
+	if currentMMDB() != running || mmdbSource == env.GeoIP {
+		t.Fatal("the running database should be kept until commit")
+	}
+
+	env.Commit()
+	if currentMMDB() != env.mmdb {
+		t.Fatal("commit should swap in the database of the rule set")
+	}
+}
@@ -0,0 +1,39 @@
+package rules
+
+import (
+	"fmt"
+
+	C "../constant"
+)
+
+type RuleSet struct {
+	provider *RuleProvider
+	adapter  string
+}
+
+func (rs *RuleSet) RuleType() C.RuleType {
+	return C.RuleSet
+}
+
+func (rs *RuleSet) IsMatch(addr *C.Addr) bool {
+	return rs.provider.IsMatch(addr)
+}
+
+func (rs *RuleSet) Adapter() string {
+	return rs.adapter
+}
+
+func (rs *RuleSet) Payload() string {
+	return rs.provider.Name()
+}
+
+func NewRuleSet(name string, adapter string, providers map[string]*RuleProvider) (*RuleSet, error) {
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("rule provider %s not found", name)
+	}
+	return &RuleSet{
+		provider: provider,
+		adapter:  adapter,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package rules
+
+import (
+	C "../constant"
+)
+
+type User struct {
+	user    string
+	adapter string
+}
+
+func (u *User) RuleType() C.RuleType {
+	return C.User
+}
+
+func (u *User) IsMatch(addr *C.Addr) bool {
+	return addr.User == u.user
+}
+
+func (u *User) Adapter() string {
+	return u.adapter
+}
+
+func (u *User) Payload() string {
+	return u.user
+}
+
+func NewUser(user string, adapter string) *User {
+	return &User{
+		user:    user,
+		adapter: adapter,
+	}
+}
@@ -2,13 +2,18 @@ package tunnel
 
 import (
 	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"../adapters"
+	"../config"
 	C "../constant"
+	"../dialer"
 	"../observable"
 	R "../rules"
 
@@ -30,9 +35,15 @@ type Tunnel struct {
 	// rules 存储所有配置的规则，按优先级排序
 	rules []C.Rule
 
+	// index 是由rules编译出的匹配索引，连续的域名后缀和IP段规则被合并为前缀树
+	index *R.Index
+
 	// proxys 存储所有可用的代理，包括DIRECT、REJECT和各种代理服务器
 	proxys map[string]C.Proxy
 
+	// providers 存储规则集提供者，供RULE-SET规则引用
+	providers map[string]*R.RuleProvider
+
 	// observable 用于日志观察，允许外部订阅日志事件
 	observable *observable.Observable
 
@@ -63,122 +74,258 @@ func (t *Tunnel) Config() ([]C.Rule, map[string]C.Proxy) {
 	return t.rules, t.proxys
 }
 
+// RuleProviders 方法返回当前的规则集提供者
+func (t *Tunnel) RuleProviders() map[string]*R.RuleProvider {
+	t.configLock.RLock()
+	defer t.configLock.RUnlock()
+	return t.providers
+}
+
 // Log 方法返回日志观察对象，允许外部订阅日志
 func (t *Tunnel) Log() *observable.Observable {
 	return t.observable
 }
 
-// UpdateConfig 方法从配置文件更新隧道配置
-// 包括代理、规则和代理组的配置
-func (t *Tunnel) UpdateConfig() (err error) {
-	// 获取当前配置
-	cfg, err := C.GetConfig()
+// Update 是校验通过但尚未生效的配置，由Commit应用或由Discard丢弃
+type Update struct {
+	cfg       *config.Config
+	proxys    map[string]C.Proxy
+	rules     []C.Rule
+	providers map[string]*R.RuleProvider
+	env       *R.Env
+}
+
+// Discard 方法停止未生效配置的url-test代理和规则集的定时更新
+func (u *Update) Discard() {
+	for _, elm := range u.proxys {
+		if urlTest, ok := elm.(*adapters.URLTest); ok {
+			urlTest.Close()
+		}
+	}
+	for _, provider := range u.providers {
+		provider.Close()
+	}
+}
+
+// UpdateConfig 方法使用解析好的配置更新隧道
+// 包括代理、规则、规则集和代理组的配置，入口的认证用户和域名嗅探由proxy包更新
+func (t *Tunnel) UpdateConfig(cfg *config.Config) error {
+	u, err := t.Prepare(cfg)
 	if err != nil {
-		return
+		return err
+	}
+	t.Commit(u)
+	return nil
+}
+
+// Prepare 方法校验配置并创建代理和规则，运行中的配置在Commit前不受影响
+func (t *Tunnel) Prepare(cfg *config.Config) (*Update, error) {
+	return t.prepare(cfg, false)
+}
+
+// CheckConfig 方法只校验配置，不会影响运行中的配置
+// 远程规则集不会下载，缺失的数据库不会下载，也不会启动任何定时任务
+func (t *Tunnel) CheckConfig(cfg *config.Config) error {
+	u, err := t.prepare(cfg, true)
+	if err != nil {
+		return err
 	}
+	u.Discard()
+	return nil
+}
 
+// prepare 方法解析并校验配置，check为true时没有任何副作用
+func (t *Tunnel) prepare(cfg *config.Config, check bool) (*Update, error) {
 	// 初始化空的代理和规则映射
 	proxys := make(map[string]C.Proxy)
 	rules := []C.Rule{}
+	providers := make(map[string]*R.RuleProvider)
 
-	// 获取配置中的各部分
-	proxysConfig := cfg.Section("Proxy")
-	rulesConfig := cfg.Section("Rule")
-	groupsConfig := cfg.Section("Proxy Group")
+	// 收集所有配置错误，全部检查完后一起返回
+	errs := config.Errors{}
+
+	// 规则可以使用的代理名称，包括代理、代理组和内置代理
+	names := map[string]bool{"DIRECT": true, "REJECT": true}
 
 	// 解析代理配置
-	for _, key := range proxysConfig.Keys() {
-		// 将代理配置按逗号分割
-		proxy := strings.Split(key.Value(), ",")
-		if len(proxy) == 0 {
+	for _, proxy := range cfg.Proxies {
+		if names[proxy.Name] {
+			errs = append(errs, cfg.Errorf("Proxy", proxy.Name, "duplicate name"))
 			continue
 		}
-		proxy = trimArr(proxy)
+		names[proxy.Name] = true
+
 		// 根据代理类型进行处理
-		switch proxy[0] {
-		// 处理Shadowsocks代理 ss, server, port, cipher, password
+		switch proxy.Type {
+		// 处理Shadowsocks代理
 		case "ss":
-			if len(proxy) < 5 {
-				continue
-			}
 			// 构造Shadowsocks URL
-			ssURL := fmt.Sprintf("ss://%s:%s@%s:%s", proxy[3], proxy[4], proxy[1], proxy[2])
+			ssURL := fmt.Sprintf("ss://%s:%s@%s", proxy.Cipher, proxy.Password, net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port)))
 			// 创建Shadowsocks代理适配器
-			ss, err := adapters.NewShadowSocks(key.Name(), ssURL, t.traffic)
+			ss, err := adapters.NewShadowSocks(proxy.Name, ssURL, t.traffic)
 			if err != nil {
-				return err
+				errs = append(errs, cfg.Errorf("Proxy", proxy.Name, "%s", err.Error()))
+				continue
 			}
-			proxys[key.Name()] = ss
+			proxys[proxy.Name] = ss
+		default:
+			errs = append(errs, cfg.Errorf("Proxy", proxy.Name, "unsupported proxy type %s", proxy.Type))
 		}
 	}
 
-	// 解析规则配置
-	for _, key := range rulesConfig.Keys() {
-		// 将规则按逗号分割
-		rule := strings.Split(key.Name(), ",")
-		if len(rule) < 3 {
+	// 代理组的名称在解析规则前登记，规则可以引用代理组
+	for _, group := range cfg.ProxyGroups {
+		if names[group.Name] {
+			errs = append(errs, cfg.Errorf("Proxy Group", group.Name, "duplicate name"))
 			continue
 		}
-		rule = trimArr(rule)
-		// 根据规则类型进行处理
-		switch rule[0] {
-		case "DOMAIN-SUFFIX":
-			// 域名后缀匹配规则
-			rules = append(rules, R.NewDomainSuffix(rule[1], rule[2]))
-		case "DOMAIN-KEYWORD":
-			// 域名关键字匹配规则
-			rules = append(rules, R.NewDomainKeyword(rule[1], rule[2]))
-		case "GEOIP":
-			// 地理位置IP匹配规则
-			rules = append(rules, R.NewGEOIP(rule[1], rule[2]))
-		case "IP-CIDR", "IP-CIDR6":
-			// IP地址段匹配规则
-			rules = append(rules, R.NewIPCIDR(rule[1], rule[2]))
-		case "FINAL":
-			// 最终匹配规则（默认规则）
-			rules = append(rules, R.NewFinal(rule[2]))
+		names[group.Name] = true
+	}
+
+	// 端口转发指定的代理必须存在，否则每个连接都会失败
+	for _, fwd := range cfg.Tunnels {
+		if fwd.Proxy != "" && !names[fwd.Proxy] {
+			errs = append(errs, cfg.Errorf("Tunnel", fwd.Name, "unknown proxy %s", fwd.Proxy))
 		}
 	}
 
-	// 解析代理组配置
-	for _, key := range groupsConfig.Keys() {
-		// 将代理组配置按逗号分割
-		rule := strings.Split(key.Value(), ",")
-		if len(rule) < 4 {
+	// IP-ASN规则使用的ASN数据库路径，相对路径以配置文件所在目录为准
+	configDir := filepath.Dir(C.ConfigPath)
+	asnPath := C.ASNPath
+	if cfg.General.ASNMMDB != "" {
+		asnPath = cfg.General.ASNMMDB
+		if !filepath.IsAbs(asnPath) {
+			asnPath = filepath.Join(configDir, asnPath)
+		}
+	}
+
+	// GEOIP规则使用的Country数据库，可以从URL或MaxMind下载并定时更新
+	geoipPath := C.MMDBPath
+	if cfg.General.GeoIPPath != "" {
+		geoipPath = cfg.General.GeoIPPath
+		if !filepath.IsAbs(geoipPath) {
+			geoipPath = filepath.Join(configDir, geoipPath)
+		}
+	}
+
+	// 规则使用的规则集和数据库，配置生效后才替换运行中的数据库
+	env := &R.Env{
+		Providers: providers,
+		ASNPath:   asnPath,
+		Check:     check,
+		GeoIP: R.GeoIPSource{
+			Path:       geoipPath,
+			URL:        cfg.General.GeoIPURL,
+			LicenseKey: cfg.General.GeoIPLicenseKey,
+			SHA256:     cfg.General.GeoIPSHA256,
+			Interval:   time.Duration(cfg.General.GeoIPInterval) * time.Second,
+		},
+	}
+
+	// 解析规则集提供者配置
+	for _, provider := range cfg.RuleProviders {
+		// 本地文件的相对路径以配置文件所在目录为准
+		source := provider.Source
+		if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") && !filepath.IsAbs(source) {
+			source = filepath.Join(configDir, source)
+		}
+
+		// 远程规则集缓存在配置目录的ruleset目录下，校验时不下载也不写缓存
+		var rp *R.RuleProvider
+		var err error
+		if check {
+			rp, err = R.CheckRuleProvider(provider.Name, provider.Behavior, source, env)
+		} else {
+			cachePath := filepath.Join(configDir, "ruleset", provider.Name)
+			rp, err = R.NewRuleProvider(provider.Name, provider.Behavior, source, cachePath, time.Duration(provider.Interval)*time.Second, env)
+		}
+		if err != nil {
+			errs = append(errs, cfg.Errorf("Rule Provider", provider.Name, "%s", err.Error()))
+			continue
+		}
+		providers[provider.Name] = rp
+	}
+
+	// 解析规则配置
+	for _, rule := range cfg.Rules {
+		key := strings.Join([]string{rule.Type, rule.Payload, rule.Target}, ",")
+
+		// 规则的代理必须存在，否则永远不会被选中
+		if !names[rule.Target] {
+			errs = append(errs, cfg.Errorf("Rule", key, "unknown proxy %s", rule.Target))
+			continue
+		}
+
+		// 根据规则类型构造规则，包括域名后缀、关键字、GEOIP、IP段、端口、逻辑组合和最终规则
+		parsed, err := R.ParseRule(rule.Type, rule.Payload, rule.Target, env)
+		if err != nil {
+			errs = append(errs, cfg.Errorf("Rule", key, "%s", err.Error()))
 			continue
 		}
-		rule = trimArr(rule)
+		rules = append(rules, parsed)
+	}
+
+	// 解析代理组配置
+	for _, group := range cfg.ProxyGroups {
 		// 根据代理组类型进行处理
-		switch rule[0] {
+		switch group.Type {
 		case "url-test":
-			// URL测试代理组
-			proxyNames := rule[1 : len(rule)-2]
-			delay, _ := strconv.Atoi(rule[len(rule)-1])
-			url := rule[len(rule)-2]
+			// URL测试代理组，收集代理组中包含的代理，只能引用之前定义的代理和代理组
 			var ps []C.Proxy
-			// 收集代理组中包含的代理
-			for _, name := range proxyNames {
-				if p, ok := proxys[name]; ok {
-					ps = append(ps, p)
+			for _, name := range group.Proxies {
+				p, ok := proxys[name]
+				if !ok {
+					errs = append(errs, cfg.Errorf("Proxy Group", group.Name, "unknown proxy %s, members must be defined before the group", name))
+					continue
 				}
+				ps = append(ps, p)
 			}
 
 			// 创建URL测试适配器
-			adapter, err := adapters.NewURLTest(key.Name(), ps, url, time.Duration(delay)*time.Second)
+			adapter, err := adapters.NewURLTest(group.Name, ps, group.URL, time.Duration(group.Delay)*time.Second)
 			if err != nil {
-				return fmt.Errorf("Config error: %s", err.Error())
+				errs = append(errs, cfg.Errorf("Proxy Group", group.Name, "%s", err.Error()))
+				continue
 			}
-			proxys[key.Name()] = adapter
+			proxys[group.Name] = adapter
+		default:
+			errs = append(errs, cfg.Errorf("Proxy Group", group.Name, "unsupported group type %s", group.Type))
 		}
 	}
 
+	u := &Update{cfg: cfg, proxys: proxys, rules: rules, providers: providers, env: env}
+
+	// 有错误时不应用任何配置
+	if len(errs) > 0 {
+		u.Discard()
+		return nil, errs
+	}
+
 	// 初始化内置代理
 	proxys["DIRECT"] = adapters.NewDirect(t.traffic) // 直连代理
 	proxys["REJECT"] = adapters.NewReject()          // 拒绝代理
+	return u, nil
+}
+
+// Commit 方法用校验通过的配置替换运行中的配置
+func (t *Tunnel) Commit(u *Update) {
+	cfg, proxys, rules := u.cfg, u.proxys, u.rules
+	index := R.NewIndex(rules)
+
+	// 出站连接绑定的网卡，使用TUN时默认绑定TUN以外的默认路由网卡，
+	// 避免默认路由指向TUN后clash自己的连接和DNS查询又回到TUN
+	iface := cfg.General.InterfaceName
+	if iface == "" && cfg.General.TunDevice != "" {
+		if iface = dialer.DefaultInterface(cfg.General.TunDevice); iface == "" {
+			t.logCh <- newLog(WARNING, "No default route besides %s, set interface-name or routing-mark to keep outbound traffic out of the TUN", cfg.General.TunDevice)
+		}
+	}
 
 	// 加写锁保护配置更新
 	t.configLock.Lock()
-	defer t.configLock.Unlock()
+
+	// 记录新旧代理的差异，配置更新后输出
+	added, removed := diffProxies(t.proxys, proxys)
 
 	// 停止旧的url-test代理
 	for _, elm := range t.proxys {
@@ -188,11 +335,52 @@ func (t *Tunnel) UpdateConfig() (err error) {
 		}
 	}
 
+	// 停止旧规则集的定时更新
+	for _, provider := range t.providers {
+		provider.Close()
+	}
+
 	// 更新代理和规则配置
 	t.proxys = proxys
 	t.rules = rules
+	t.index = index
+	t.providers = u.providers
 
-	return nil
+	// 启动新的url-test代理
+	for _, elm := range proxys {
+		if urlTest, ok := elm.(*adapters.URLTest); ok {
+			urlTest.Start()
+		}
+	}
+
+	// 更新出站连接绑定的网卡和路由标记
+	dialer.Set(iface, cfg.General.RoutingMark)
+
+	// 新规则已替换旧规则，应用新配置的GEOIP和ASN数据库
+	u.env.Commit()
+
+	t.configLock.Unlock()
+
+	// 日志在释放锁后发送，避免阻塞正在匹配规则的连接
+	t.logCh <- newLog(INFO, "Config updated: %d proxies, added [%s], removed [%s], %d rules",
+		len(proxys)-2, strings.Join(added, ", "), strings.Join(removed, ", "), len(rules))
+}
+
+// diffProxies 比较新旧代理，返回新增和删除的代理名称，不包括内置代理
+func diffProxies(old, new map[string]C.Proxy) (added, removed []string) {
+	for name := range new {
+		if _, ok := old[name]; !ok && name != "DIRECT" && name != "REJECT" {
+			added = append(added, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok && name != "DIRECT" && name != "REJECT" {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return
 }
 
 // process 方法是隧道的核心处理循环
@@ -217,8 +405,22 @@ func (t *Tunnel) handleConn(localConn C.ServerAdapter) {
 	// 获取连接的目标地址
 	addr := localConn.Addr()
 
-	// 根据规则匹配合适的代理
-	proxy := t.match(addr)
+	// 根据规则匹配合适的代理，指定了代理的入口直接使用指定的代理
+	var proxy C.Proxy
+	if adapter, ok := localConn.(C.SpecifiedServerAdapter); ok && adapter.ProxyName() != "" {
+		proxy = t.specified(addr, adapter.ProxyName())
+		if proxy == nil {
+			return
+		}
+	} else {
+		proxy = t.match(addr)
+	}
+
+	// 普通HTTP请求由入口自己通过连接池复用上游连接
+	if adapter, ok := localConn.(C.ProxyServerAdapter); ok {
+		adapter.ConnectProxy(proxy)
+		return
+	}
 
 	// 使用选中的代理建立远程连接
 	remoConn, err := proxy.Generator(addr)
@@ -242,19 +444,15 @@ func (t *Tunnel) match(addr *C.Addr) C.Proxy {
 	t.configLock.RLock()
 	defer t.configLock.RUnlock()
 
-	// 遍历所有规则
-	for _, rule := range t.rules {
-		// 检查规则是否匹配目标地址
-		if rule.IsMatch(addr) {
-			// 获取规则对应的代理
-			a, ok := t.proxys[rule.Adapter()]
-			if !ok {
-				continue
-			}
-			// 记录匹配日志
+	// 通过索引查找第一条匹配的规则，结果与按顺序遍历所有规则一致
+	if rule := t.index.Match(addr); rule != nil {
+		// 记录匹配日志
+		if addr.User != "" {
+			t.logCh <- newLog(INFO, "%v (user %s) match %s using %s", addr.String(), addr.User, rule.RuleType().String(), rule.Adapter())
+		} else {
 			t.logCh <- newLog(INFO, "%v match %s using %s", addr.String(), rule.RuleType().String(), rule.Adapter())
-			return a
 		}
+		return t.proxys[rule.Adapter()]
 	}
 
 	// 如果没有规则匹配，使用DIRECT直连代理
@@ -262,6 +460,22 @@ func (t *Tunnel) match(addr *C.Addr) C.Proxy {
 	return t.proxys["DIRECT"]
 }
 
+// specified 方法返回入口指定的代理，不经过规则匹配
+// 代理不存在时返回nil
+func (t *Tunnel) specified(addr *C.Addr, name string) C.Proxy {
+	t.configLock.RLock()
+	defer t.configLock.RUnlock()
+
+	proxy, ok := t.proxys[name]
+	if !ok {
+		t.logCh <- newLog(WARNING, "%v specified proxy %s not found", addr.String(), name)
+		return nil
+	}
+
+	t.logCh <- newLog(INFO, "%v using specified %s", addr.String(), name)
+	return proxy
+}
+
 // newTunnel 创建一个新的隧道实例
 func newTunnel() *Tunnel {
 	// 创建日志通道
@@ -271,6 +485,7 @@ func newTunnel() *Tunnel {
 	tunnel := &Tunnel{
 		queue:      channels.NewInfiniteChannel(),   // 创建无限缓冲通道
 		proxys:     make(map[string]C.Proxy),        // 初始化代理映射
+		index:      R.NewIndex(nil),                 // 初始化空的规则索引
 		observable: observable.NewObservable(logCh), // 创建可观察对象
 		logCh:      logCh,                           // 设置日志通道
 		configLock: &sync.RWMutex{},                 // 初始化读写锁
@@ -0,0 +1,27 @@
+package tunnel
+
+import (
+	"reflect"
+	"testing"
+
+	C "../constant"
+)
+
+func TestDiffProxies(t *testing.T) {
+	old := map[string]C.Proxy{"DIRECT": nil, "REJECT": nil, "Proxy1": nil, "Proxy2": nil}
+	new := map[string]C.Proxy{"DIRECT": nil, "REJECT": nil, "Proxy2": nil, "Proxy4": nil, "Proxy3": nil}
+
+	added, removed := diffProxies(old, new)
+	if !reflect.DeepEqual(added, []string{"Proxy3", "Proxy4"}) {
+		t.Errorf("unexpected added %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"Proxy1"}) {
+		t.Errorf("unexpected removed %v", removed)
+	}
+
+	// the first config has nothing to compare with, built-in proxies are left out
+	added, removed = diffProxies(nil, old)
+	if !reflect.DeepEqual(added, []string{"Proxy1", "Proxy2"}) || removed != nil {
+		t.Errorf("unexpected added %v, removed %v", added, removed)
+	}
+}
@@ -1,12 +0,0 @@
-package tunnel
-
-import (
-	"strings"
-)
-
-func trimArr(arr []string) (r []string) {
-	for _, e := range arr {
-		r = append(r, strings.Trim(e, " "))
-	}
-	return
-}